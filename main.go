@@ -60,7 +60,7 @@ func main() {
 
 	// setup kill / rehash hooks
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	go signalHandler(c, server, mainLog)
 
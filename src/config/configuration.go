@@ -17,27 +17,76 @@ type Configuration struct {
 
 	Service struct {
 		Listen struct {
-			IP   string
-			Port uint16
+			IP         string
+			Port       uint16
+			Additional []string
 		}
 		Hostname  string
 		Templates struct {
 			MOTD       string
 			TimeFormat string
 		}
-		ServerTTL    Duration
+		ServerTTL Duration
+		Probe     struct {
+			MaxFailures   int
+			RetryInterval Duration
+			MaxBackoff    Duration
+			FlapMemory    Duration
+		}
 		ID           uint16
 		ServersPerIP uint16
-		Banned       struct {
+		Quotas       struct {
+			ServersPerSubnet uint16
+			IPv4SubnetPrefix int
+			IPv6SubnetPrefix int
+			Rules            []QuotaRule
+		}
+		Banned struct {
 			Networks []string
 			Message  string
+			File     string
+		}
+		Pinned    []PinnedServer
+		Allowlist struct {
+			Enabled bool
+			Servers []string
+		}
+		NamePolicy struct {
+			Enabled bool
+			Mask    string
+			Rules   []NameRule
+		}
+		Registry struct {
+			File string
+		}
+		StunResponder struct {
+			Enabled bool
+		}
+		RateLimit struct {
+			Enabled             bool
+			QueriesPerMinute    int
+			QueryBurst          int
+			HeartbeatsPerMinute int
+			HeartbeatBurst      int
+			BanThreshold        int
+			BanDuration         Duration
+		}
+		ResponseBudget struct {
+			Enabled        bool
+			BytesPerWindow int
+			Window         Duration
+			Challenge      bool
 		}
 	}
 
 	Poll struct {
-		Enabled      bool
-		Interval     Duration
-		KnownMasters []string
+		Enabled         bool
+		Interval        Duration
+		Timeout         Duration
+		Jitter          Duration
+		MaxBackoff      Duration
+		AdvertisePolled bool
+		KnownMasters    []string
 	}
 
 	HTTPD struct {
@@ -61,13 +110,59 @@ type Configuration struct {
 			MaxPacketSize     uint16
 			MaxBufferSize     uint16
 			StunServers       []string
+			StunEnabled       bool
+			StunRefresh       Duration
+			ExternalIP        string
+			InterfaceScan     Duration
+			NATRules          []NATRule
 		}
 		Maintenance struct {
-			Interval Duration
+			Interval     Duration
+			SweepWorkers int
+			SweepTimeout Duration
+		}
+		Verification struct {
+			Workers   int
+			QueueSize int
+		}
+		Ingress struct {
+			Workers      int
+			QueueSize    int
+			DedupeWindow Duration
 		}
 	}
 }
 
+// NameRule matches server names against a regular expression and/or a list of words
+type NameRule struct {
+	Name     string
+	Action   string
+	Pattern  string
+	Words    []string
+	WordFile string
+}
+
+// QuotaRule overrides the server limits for every ip address inside a network, a limit of 0 keeps the default
+type QuotaRule struct {
+	Network          string
+	ServersPerIP     uint16
+	ServersPerSubnet uint16
+}
+
+// PinnedServer is always advertised at the top of the server lists, the name is shown until the server answers
+type PinnedServer struct {
+	Address string
+	Name    string
+}
+
+// NATRule advertises servers inside a network as a different address to clients outside of the client networks,
+// which default to the server network
+type NATRule struct {
+	Servers     string
+	AdvertiseAs string
+	Clients     []string
+}
+
 func (s *Service) SetDefaults() {
 	components := make([]string, 0)
 	for _, v := range service.List {
@@ -80,20 +175,56 @@ func (s *Service) SetDefaults() {
 
 	s.viper.SetDefault("Service.Listen.IP", "")
 	s.viper.SetDefault("Service.Listen.Port", 29000) //nolint:gomnd
+	s.viper.SetDefault("Service.Listen.Additional", []string{})
 
 	s.viper.SetDefault("Service.ServerTTL", "5m")
+	s.viper.SetDefault("Service.Probe.MaxFailures", 3) //nolint:gomnd
+	s.viper.SetDefault("Service.Probe.RetryInterval", "30s")
+	s.viper.SetDefault("Service.Probe.MaxBackoff", "10m")
+	s.viper.SetDefault("Service.Probe.FlapMemory", "1h")
 
 	s.viper.SetDefault("Service.Hostname", "")
 	s.viper.SetDefault("Service.Templates.MOTD", "")
 	s.viper.SetDefault("Service.Templates.TimeFormat", "Y-m-d H:i:s T")
-	s.viper.SetDefault("Service.ID", 99)           //nolint:gomnd
+	s.viper.SetDefault("Service.ID", DefaultMasterID)
 	s.viper.SetDefault("Service.ServersPerIP", 30) //nolint:gomnd
+	s.viper.SetDefault("Service.Quotas.ServersPerSubnet", 0)
+	s.viper.SetDefault("Service.Quotas.IPv4SubnetPrefix", 24) //nolint:gomnd
+	s.viper.SetDefault("Service.Quotas.IPv6SubnetPrefix", 64) //nolint:gomnd
+	s.viper.SetDefault("Service.Quotas.Rules", []QuotaRule{})
 
 	s.viper.SetDefault("Service.Banned.Message", "You've been banned!")
 	s.viper.SetDefault("Service.Banned.Networks", []string{"224.0.0.0/4"})
+	s.viper.SetDefault("Service.Banned.File", "mstrsvr.bans.json")
+	s.viper.SetDefault("Service.Pinned", []PinnedServer{})
+	s.viper.SetDefault("Service.Allowlist.Enabled", false)
+	s.viper.SetDefault("Service.Allowlist.Servers", []string{})
+	s.viper.SetDefault("Service.NamePolicy.Enabled", false)
+	s.viper.SetDefault("Service.NamePolicy.Mask", "*")
+	s.viper.SetDefault("Service.NamePolicy.Rules", []NameRule{})
+
+	s.viper.SetDefault("Service.Registry.File", "mstrsvr.registry.json")
+	s.viper.SetDefault("Service.StunResponder.Enabled", false)
+
+	s.viper.SetDefault("Service.RateLimit.Enabled", true)
+	s.viper.SetDefault("Service.RateLimit.QueriesPerMinute", 30)    //nolint:gomnd
+	s.viper.SetDefault("Service.RateLimit.QueryBurst", 10)          //nolint:gomnd
+	s.viper.SetDefault("Service.RateLimit.HeartbeatsPerMinute", 60) //nolint:gomnd
+	s.viper.SetDefault("Service.RateLimit.HeartbeatBurst", 30)      //nolint:gomnd
+	s.viper.SetDefault("Service.RateLimit.BanThreshold", 50)        //nolint:gomnd
+	s.viper.SetDefault("Service.RateLimit.BanDuration", "10m")
+
+	s.viper.SetDefault("Service.ResponseBudget.Enabled", true)
+	s.viper.SetDefault("Service.ResponseBudget.BytesPerWindow", 32768) //nolint:gomnd
+	s.viper.SetDefault("Service.ResponseBudget.Window", "1m")
+	s.viper.SetDefault("Service.ResponseBudget.Challenge", true)
 
 	s.viper.SetDefault("Poll.Enabled", false)
 	s.viper.SetDefault("Poll.Interval", "5m")
+	s.viper.SetDefault("Poll.Timeout", "5s")
+	s.viper.SetDefault("Poll.Jitter", "30s")
+	s.viper.SetDefault("Poll.MaxBackoff", "6h")
+	s.viper.SetDefault("Poll.AdvertisePolled", true)
 	s.viper.SetDefault("Poll.KnownMasters", []string{"master1.starsiegeplayers.com:29000", "master2.starsiegeplayers.com:29000", "master3.starsiegeplayers.com:29000"})
 
 	s.viper.SetDefault("HTTPD.Enabled", true)
@@ -104,10 +235,22 @@ func (s *Service) SetDefaults() {
 
 	s.viper.SetDefault("Advanced.Verbose", false)
 	s.viper.SetDefault("Advanced.Maintenance.Interval", "1m")
+	s.viper.SetDefault("Advanced.Maintenance.SweepWorkers", 16) //nolint:gomnd
+	s.viper.SetDefault("Advanced.Maintenance.SweepTimeout", "45s")
+	s.viper.SetDefault("Advanced.Verification.Workers", 8)     //nolint:gomnd
+	s.viper.SetDefault("Advanced.Verification.QueueSize", 256) //nolint:gomnd
+	s.viper.SetDefault("Advanced.Ingress.Workers", 4)          //nolint:gomnd
+	s.viper.SetDefault("Advanced.Ingress.QueueSize", 1024)     //nolint:gomnd
+	s.viper.SetDefault("Advanced.Ingress.DedupeWindow", "1s")
 	s.viper.SetDefault("Advanced.Network.ConnectionTimeout", "2s")
 	s.viper.SetDefault("Advanced.Network.MaxPacketSize", 512)   //nolint:gomnd
 	s.viper.SetDefault("Advanced.Network.MaxBufferSize", 32768) //nolint:gomnd
 	s.viper.SetDefault("Advanced.Network.StunServers", []string{"stun.l.google.com:19302", "stun1.l.google.com:19302", "stun2.l.google.com:19302", "stun3.l.google.com:19302", "stun4.l.google.com:19302"})
+	s.viper.SetDefault("Advanced.Network.StunEnabled", true)
+	s.viper.SetDefault("Advanced.Network.StunRefresh", "30m")
+	s.viper.SetDefault("Advanced.Network.ExternalIP", "")
+	s.viper.SetDefault("Advanced.Network.InterfaceScan", "30s")
+	s.viper.SetDefault("Advanced.Network.NATRules", []NATRule{})
 }
 
 func (s *Service) UpdateValues(c *Configuration) error {
@@ -125,20 +268,56 @@ func (s *Service) setValues() {
 
 	s.viper.Set("Service.Listen.IP", f.Service.Listen.IP)
 	s.viper.Set("Service.Listen.Port", f.Service.Listen.Port)
+	s.viper.Set("Service.Listen.Additional", f.Service.Listen.Additional)
 
 	s.viper.Set("Service.ServerTTL", f.Service.ServerTTL)
+	s.viper.Set("Service.Probe.MaxFailures", f.Service.Probe.MaxFailures)
+	s.viper.Set("Service.Probe.RetryInterval", f.Service.Probe.RetryInterval)
+	s.viper.Set("Service.Probe.MaxBackoff", f.Service.Probe.MaxBackoff)
+	s.viper.Set("Service.Probe.FlapMemory", f.Service.Probe.FlapMemory)
 
 	s.viper.Set("Service.Hostname", f.Service.Hostname)
 	s.viper.Set("Service.Templates.MOTD", f.Service.Templates.MOTD)
 	s.viper.Set("Service.Templates.TimeFormat", f.Service.Templates.TimeFormat)
 	s.viper.Set("Service.ID", f.Service.ID)
 	s.viper.Set("Service.ServersPerIP", f.Service.ServersPerIP)
+	s.viper.Set("Service.Quotas.ServersPerSubnet", f.Service.Quotas.ServersPerSubnet)
+	s.viper.Set("Service.Quotas.IPv4SubnetPrefix", f.Service.Quotas.IPv4SubnetPrefix)
+	s.viper.Set("Service.Quotas.IPv6SubnetPrefix", f.Service.Quotas.IPv6SubnetPrefix)
+	s.viper.Set("Service.Quotas.Rules", f.Service.Quotas.Rules)
 
 	s.viper.Set("Service.Banned.Message", f.Service.Banned.Message)
 	s.viper.Set("Service.Banned.Networks", f.Service.Banned.Networks)
+	s.viper.Set("Service.Banned.File", f.Service.Banned.File)
+	s.viper.Set("Service.Pinned", f.Service.Pinned)
+	s.viper.Set("Service.Allowlist.Enabled", f.Service.Allowlist.Enabled)
+	s.viper.Set("Service.Allowlist.Servers", f.Service.Allowlist.Servers)
+	s.viper.Set("Service.NamePolicy.Enabled", f.Service.NamePolicy.Enabled)
+	s.viper.Set("Service.NamePolicy.Mask", f.Service.NamePolicy.Mask)
+	s.viper.Set("Service.NamePolicy.Rules", f.Service.NamePolicy.Rules)
+
+	s.viper.Set("Service.Registry.File", f.Service.Registry.File)
+	s.viper.Set("Service.StunResponder.Enabled", f.Service.StunResponder.Enabled)
+
+	s.viper.Set("Service.RateLimit.Enabled", f.Service.RateLimit.Enabled)
+	s.viper.Set("Service.RateLimit.QueriesPerMinute", f.Service.RateLimit.QueriesPerMinute)
+	s.viper.Set("Service.RateLimit.QueryBurst", f.Service.RateLimit.QueryBurst)
+	s.viper.Set("Service.RateLimit.HeartbeatsPerMinute", f.Service.RateLimit.HeartbeatsPerMinute)
+	s.viper.Set("Service.RateLimit.HeartbeatBurst", f.Service.RateLimit.HeartbeatBurst)
+	s.viper.Set("Service.RateLimit.BanThreshold", f.Service.RateLimit.BanThreshold)
+	s.viper.Set("Service.RateLimit.BanDuration", f.Service.RateLimit.BanDuration)
+
+	s.viper.Set("Service.ResponseBudget.Enabled", f.Service.ResponseBudget.Enabled)
+	s.viper.Set("Service.ResponseBudget.BytesPerWindow", f.Service.ResponseBudget.BytesPerWindow)
+	s.viper.Set("Service.ResponseBudget.Window", f.Service.ResponseBudget.Window)
+	s.viper.Set("Service.ResponseBudget.Challenge", f.Service.ResponseBudget.Challenge)
 
 	s.viper.Set("Poll.Enabled", f.Poll.Enabled)
 	s.viper.Set("Poll.Interval", f.Poll.Interval)
+	s.viper.Set("Poll.Timeout", f.Poll.Timeout)
+	s.viper.Set("Poll.Jitter", f.Poll.Jitter)
+	s.viper.Set("Poll.MaxBackoff", f.Poll.MaxBackoff)
+	s.viper.Set("Poll.AdvertisePolled", f.Poll.AdvertisePolled)
 	s.viper.Set("Poll.KnownMasters", f.Poll.KnownMasters)
 
 	s.viper.Set("HTTPD.Enabled", f.HTTPD.Enabled)
@@ -150,10 +329,22 @@ func (s *Service) setValues() {
 
 	s.viper.Set("Advanced.Verbose", f.Advanced.Verbose)
 	s.viper.Set("Advanced.Maintenance.Interval", f.Advanced.Maintenance.Interval)
+	s.viper.Set("Advanced.Maintenance.SweepWorkers", f.Advanced.Maintenance.SweepWorkers)
+	s.viper.Set("Advanced.Maintenance.SweepTimeout", f.Advanced.Maintenance.SweepTimeout)
+	s.viper.Set("Advanced.Verification.Workers", f.Advanced.Verification.Workers)
+	s.viper.Set("Advanced.Verification.QueueSize", f.Advanced.Verification.QueueSize)
+	s.viper.Set("Advanced.Ingress.Workers", f.Advanced.Ingress.Workers)
+	s.viper.Set("Advanced.Ingress.QueueSize", f.Advanced.Ingress.QueueSize)
+	s.viper.Set("Advanced.Ingress.DedupeWindow", f.Advanced.Ingress.DedupeWindow)
 	s.viper.Set("Advanced.Network.ConnectionTimeout", f.Advanced.Network.ConnectionTimeout)
 	s.viper.Set("Advanced.Network.MaxPacketSize", f.Advanced.Network.MaxPacketSize)
 	s.viper.Set("Advanced.Network.MaxBufferSize", f.Advanced.Network.MaxBufferSize)
 	s.viper.Set("Advanced.Network.StunServers", f.Advanced.Network.StunServers)
+	s.viper.Set("Advanced.Network.StunEnabled", f.Advanced.Network.StunEnabled)
+	s.viper.Set("Advanced.Network.StunRefresh", f.Advanced.Network.StunRefresh)
+	s.viper.Set("Advanced.Network.ExternalIP", f.Advanced.Network.ExternalIP)
+	s.viper.Set("Advanced.Network.InterfaceScan", f.Advanced.Network.InterfaceScan)
+	s.viper.Set("Advanced.Network.NATRules", f.Advanced.Network.NATRules)
 }
 
 func (s *Service) Write() (err error) {
@@ -48,6 +48,7 @@ const (
 	EnvPrefix              = "mstrsvr"
 	EggURL                 = "https://youtu.be/pY725Ya74VU"
 	MinimumSecureKeyLength = 64
+	DefaultMasterID        = 99
 )
 
 func (s *Service) Init(services *map[service.ID]service.Interface) error {
@@ -0,0 +1,121 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/master"
+)
+
+type HTTPAdminAllowlist struct {
+	master.AllowlistStats
+	HTTPError
+}
+
+type HTTPAdminAllowlistAction struct {
+	Address string
+	Action  string
+}
+
+func (s *Service) routeGetAdminAllowlist(w http.ResponseWriter, _ *http.Request) {
+	s.router.jsonOut(w, HTTPAdminAllowlist{
+		AllowlistStats: s.services.Master.AllowlistStats(),
+		HTTPError:      HTTPError{},
+	})
+}
+
+func (s *Service) routePostAdminAllowlist(w http.ResponseWriter, r *http.Request) {
+	decode := json.NewDecoder(r.Body)
+	form := &HTTPAdminAllowlistAction{}
+
+	err := decode.Decode(form)
+	if err != nil {
+		s.router.jsonOut(w, HTTPError{
+			Error:     "invalid JSON provided",
+			ErrorCode: http.StatusUnprocessableEntity,
+		})
+
+		return
+	}
+
+	username := s.adminSessionUsername(r)
+
+	switch form.Action {
+	case "approve":
+		var ipPort string
+
+		ipPort, err = s.services.Master.ApproveServer(form.Address, username)
+		if errors.Is(err, master.ErrInvalidServerAddress) {
+			break
+		}
+
+		if err != nil {
+			s.logs.HTTPD.LogAlertf("error while verifying approved server %s [%s]", ipPort, err)
+		}
+
+		// persist the approval so it survives a rehash
+		err = s.addAllowlistEntry(ipPort)
+		if err != nil {
+			s.logs.HTTPD.LogAlertf("error while writing config file to disk [%s]", err)
+			s.router.jsonOut(w, HTTPAdminAllowlist{
+				AllowlistStats: s.services.Master.AllowlistStats(),
+				HTTPError: HTTPError{
+					Error:     "error while writing config file to disk",
+					ErrorCode: 1001, //nolint:gomnd
+				},
+			})
+
+			return
+		}
+
+	case "dismiss":
+		err = s.services.Master.DismissPendingServer(form.Address, username)
+
+	default:
+		s.router.jsonOut(w, HTTPError{
+			Error:     "unknown action requested",
+			ErrorCode: http.StatusBadRequest,
+		})
+
+		return
+	}
+
+	switch {
+	case errors.Is(err, master.ErrInvalidServerAddress):
+		s.router.jsonOut(w, HTTPError{
+			Error:     err.Error(),
+			ErrorCode: http.StatusUnprocessableEntity,
+		})
+
+	case errors.Is(err, master.ErrServerNotPending):
+		s.router.jsonOut(w, HTTPError{
+			Error:     err.Error(),
+			ErrorCode: http.StatusNotFound,
+		})
+
+	default:
+		s.router.jsonOut(w, HTTPAdminAllowlist{
+			AllowlistStats: s.services.Master.AllowlistStats(),
+			HTTPError:      HTTPError{},
+		})
+	}
+}
+
+// addAllowlistEntry appends an approved server to the configured allowlist and writes the config file
+func (s *Service) addAllowlistEntry(ipPort string) error {
+	values := s.services.Config.Values
+
+	values.Lock()
+	for _, v := range values.Service.Allowlist.Servers {
+		if v == ipPort {
+			values.Unlock()
+			return nil
+		}
+	}
+
+	values.Service.Allowlist.Servers = append(values.Service.Allowlist.Servers, ipPort)
+	values.Unlock()
+
+	return s.services.Config.Write()
+}
@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/StarsiegePlayers/neos-thicc-master/src/config"
+	"github.com/StarsiegePlayers/neos-thicc-master/src/master"
 	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+	"github.com/StarsiegePlayers/neos-thicc-master/src/stun"
 
 	"github.com/aykevl/pwhash"
 )
@@ -31,6 +33,24 @@ type HTTPAdminSettings struct {
 	HTTPError
 }
 
+type HTTPAdminMasterStats struct {
+	Verification   master.VerificationStats
+	Ingress        master.IngressStats
+	RateLimit      master.RateLimitStats
+	ResponseBudget master.ResponseBudgetStats
+	ListCache      master.ListCacheStats
+	Sweep          master.SweepStats
+	Listeners      []master.ListenerStats
+	Quotas         master.QuotaStats
+	HTTPError
+}
+
+type HTTPAdminServiceStatus struct {
+	Services map[string]string
+	STUN     stun.Stats
+	HTTPError
+}
+
 type HTTPAdminPowerAction struct {
 	Action string
 	HTTPError
@@ -265,7 +285,25 @@ func (s *Service) routeGetAdminServiceStatus(w http.ResponseWriter, _ *http.Requ
 		output[k.String()] = v.Status().String()
 	}
 
-	s.router.jsonOut(w, output)
+	s.router.jsonOut(w, HTTPAdminServiceStatus{
+		Services:  output,
+		STUN:      s.services.STUN.Stats(),
+		HTTPError: HTTPError{},
+	})
+}
+
+func (s *Service) routeGetAdminMasterStats(w http.ResponseWriter, _ *http.Request) {
+	s.router.jsonOut(w, HTTPAdminMasterStats{
+		Verification:   s.services.Master.VerificationStats(),
+		Ingress:        s.services.Master.IngressStats(),
+		RateLimit:      s.services.Master.RateLimitStats(),
+		ResponseBudget: s.services.Master.ResponseBudgetStats(),
+		ListCache:      s.services.Master.ListCacheStats(),
+		Sweep:          s.services.Master.SweepStats(),
+		Listeners:      s.services.Master.ListenerStats(),
+		Quotas:         s.services.Master.QuotaStats(),
+		HTTPError:      HTTPError{},
+	})
 }
 
 func (s *Service) routePostAdminServerSettings(w http.ResponseWriter, r *http.Request) {
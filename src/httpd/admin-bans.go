@@ -0,0 +1,152 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/config"
+	"github.com/StarsiegePlayers/neos-thicc-master/src/master"
+)
+
+type HTTPAdminBans struct {
+	Bans []master.Ban
+	HTTPError
+}
+
+type HTTPAdminBan struct {
+	Ban master.Ban
+	HTTPError
+}
+
+type HTTPAdminBanForm struct {
+	ID       string
+	CIDR     string
+	Reason   string
+	MOTD     string
+	Duration config.Duration
+}
+
+func (s *Service) routeGetAdminBans(w http.ResponseWriter, _ *http.Request) {
+	s.router.jsonOut(w, HTTPAdminBans{
+		Bans:      s.services.Master.Bans(),
+		HTTPError: HTTPError{},
+	})
+}
+
+func (s *Service) routePostAdminBan(w http.ResponseWriter, r *http.Request) {
+	decode := json.NewDecoder(r.Body)
+	form := &HTTPAdminBanForm{}
+
+	err := decode.Decode(form)
+	if err != nil {
+		s.router.jsonOut(w, HTTPError{
+			Error:     "invalid JSON provided",
+			ErrorCode: http.StatusUnprocessableEntity,
+		})
+
+		return
+	}
+
+	if form.Reason == "" {
+		s.router.jsonOut(w, HTTPError{
+			Error:     "a ban reason is required",
+			ErrorCode: http.StatusUnprocessableEntity,
+		})
+
+		return
+	}
+
+	ban := master.Ban{
+		CIDR:    form.CIDR,
+		Reason:  form.Reason,
+		MOTD:    form.MOTD,
+		AddedBy: s.adminSessionUsername(r),
+	}
+
+	// a zero duration bans forever
+	if form.Duration.Duration > 0 {
+		ban.Expires = time.Now().Add(form.Duration.Duration)
+	}
+
+	ban, err = s.services.Master.AddBan(ban)
+
+	switch {
+	case errors.Is(err, master.ErrInvalidNetwork):
+		s.router.jsonOut(w, HTTPError{
+			Error:     err.Error(),
+			ErrorCode: http.StatusUnprocessableEntity,
+		})
+
+		return
+
+	case err != nil && ban.ID == "":
+		s.logs.HTTPD.LogAlertf("error while adding ban [%s]", err)
+		s.router.jsonOut(w, HTTPError{
+			Error:     "error while adding ban",
+			ErrorCode: http.StatusInternalServerError,
+		})
+
+		return
+
+	case err != nil:
+		// the ban is active but didn't make it to disk
+		s.logs.HTTPD.LogAlertf("error while writing ban file to disk [%s]", err)
+		s.router.jsonOut(w, HTTPAdminBan{
+			Ban: ban,
+			HTTPError: HTTPError{
+				Error:     "error while writing ban file to disk",
+				ErrorCode: 1001, //nolint:gomnd
+			},
+		})
+
+		return
+	}
+
+	s.router.jsonOut(w, HTTPAdminBan{
+		Ban:       ban,
+		HTTPError: HTTPError{},
+	})
+}
+
+func (s *Service) routeDeleteAdminBan(w http.ResponseWriter, r *http.Request) {
+	decode := json.NewDecoder(r.Body)
+	form := &HTTPAdminBanForm{}
+
+	err := decode.Decode(form)
+	if err != nil {
+		s.router.jsonOut(w, HTTPError{
+			Error:     "invalid JSON provided",
+			ErrorCode: http.StatusUnprocessableEntity,
+		})
+
+		return
+	}
+
+	err = s.services.Master.RemoveBan(form.ID, s.adminSessionUsername(r))
+
+	switch {
+	case errors.Is(err, master.ErrBanNotFound):
+		s.router.jsonOut(w, HTTPError{
+			Error:     err.Error(),
+			ErrorCode: http.StatusNotFound,
+		})
+
+		return
+
+	case err != nil:
+		s.logs.HTTPD.LogAlertf("error while writing ban file to disk [%s]", err)
+		s.router.jsonOut(w, HTTPError{
+			Error:     "error while writing ban file to disk",
+			ErrorCode: 1001, //nolint:gomnd
+		})
+
+		return
+	}
+
+	s.router.jsonOut(w, HTTPAdminBans{
+		Bans:      s.services.Master.Bans(),
+		HTTPError: HTTPError{},
+	})
+}
@@ -0,0 +1,55 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/master"
+)
+
+type HTTPAdminFlagged struct {
+	Servers []master.FlaggedServer
+	HTTPError
+}
+
+type HTTPAdminFlaggedReview struct {
+	Address string
+}
+
+func (s *Service) routeGetAdminFlagged(w http.ResponseWriter, _ *http.Request) {
+	s.router.jsonOut(w, HTTPAdminFlagged{
+		Servers:   s.services.Master.FlaggedServers(),
+		HTTPError: HTTPError{},
+	})
+}
+
+func (s *Service) routePostAdminFlaggedReview(w http.ResponseWriter, r *http.Request) {
+	decode := json.NewDecoder(r.Body)
+	form := &HTTPAdminFlaggedReview{}
+
+	err := decode.Decode(form)
+	if err != nil {
+		s.router.jsonOut(w, HTTPError{
+			Error:     "invalid JSON provided",
+			ErrorCode: http.StatusUnprocessableEntity,
+		})
+
+		return
+	}
+
+	err = s.services.Master.ReviewFlaggedServer(form.Address, s.adminSessionUsername(r))
+	if errors.Is(err, master.ErrNotFlagged) {
+		s.router.jsonOut(w, HTTPError{
+			Error:     err.Error(),
+			ErrorCode: http.StatusNotFound,
+		})
+
+		return
+	}
+
+	s.router.jsonOut(w, HTTPAdminFlagged{
+		Servers:   s.services.Master.FlaggedServers(),
+		HTTPError: HTTPError{},
+	})
+}
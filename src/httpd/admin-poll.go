@@ -0,0 +1,28 @@
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/polling"
+)
+
+type HTTPAdminPollHealth struct {
+	Enabled bool
+	Masters []polling.MasterHealth
+	HTTPError
+}
+
+func (s *Service) routeGetAdminPollHealth(w http.ResponseWriter, _ *http.Request) {
+	out := HTTPAdminPollHealth{
+		Masters:   make([]polling.MasterHealth, 0),
+		HTTPError: HTTPError{},
+	}
+
+	// skip if poll service isn't running
+	if s.services.Poll != nil {
+		out.Enabled = true
+		out.Masters = s.services.Poll.MasterHealth()
+	}
+
+	s.router.jsonOut(w, out)
+}
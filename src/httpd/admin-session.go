@@ -145,3 +145,22 @@ func (s *Service) adminCreateSession(username string, td *HTTPAdminTokenData) er
 
 	return nil
 }
+
+// adminSessionUsername returns the name of the admin the request was made by
+func (s *Service) adminSessionUsername(r *http.Request) string {
+	uid, err := s.adminExtractTokenData(r)
+	if err != nil {
+		return ""
+	}
+
+	cache, ok := s.cache[cacheAdminSessions].(map[string]*HTTPAdminSession)
+	if !ok {
+		return ""
+	}
+
+	if session, ok := cache[uid]; ok {
+		return session.Username
+	}
+
+	return ""
+}
@@ -2,6 +2,7 @@ package httpd
 
 const (
 	cacheMultiplayer = HTTPCacheID(iota)
+	cacheMultiplayerServers
 	cacheAdminSessions
 	cacheThrottle
 )
@@ -6,128 +6,225 @@ import (
 	"sort"
 	"time"
 
+	"github.com/StarsiegePlayers/neos-thicc-master/src/master"
+
 	"github.com/StarsiegePlayers/darkstar-query-go/v2/query"
 )
 
+// MasterQuery is a known master's poll health along with its last answer, which is nil while it is failing
 type MasterQuery struct {
 	*query.MasterQuery
-	ServerCount int
+	Address             string
+	ServerCount         int
+	Attempts            int
+	Successes           int
+	ConsecutiveFailures int
+	LastSuccess         time.Time
+	AverageLatency      time.Duration
 }
 
 func (s *Service) maintenanceMultiplayerServersCache() (cacheData *CacheResponse) {
-	CacheResponses := make(map[string]*CacheResponse)
-	rawGames := make([]*query.PingInfoQuery, 0)
-	localizedGames := rawGames
+	rawGames := make([]*Game, 0)
 	errors := make([]string, 0)
 	masters := make([]*MasterQuery, 0)
 
 	// Master should never be nil, but just in case
 	if s.services.Master != nil {
-		s.services.Master.Lock()
+		// the snapshot is lock free and never modified, so nothing here may write to its entries
+		for _, v := range s.services.Master.Snapshot().Servers {
+			// restored servers have no game info until they pass verification
+			if v.Restored {
+				continue
+			}
 
-		for _, v := range s.services.Master.ServerList {
-			rawGames = append(rawGames, v.PingInfoQuery)
-		}
+			game := &Game{
+				PingInfoQuery: v.PingInfoQuery,
+				Stale:         v.Stale,
+				Featured:      v.Pinned,
+				Origin:        v.Origin,
+				OriginMaster:  v.OriginMaster,
+			}
 
-		s.services.Master.Unlock()
+			// names caught by a name policy mask rule are only ever shown masked
+			if v.MaskedName != "" {
+				game = withName(game, v.MaskedName)
+			}
+
+			rawGames = append(rawGames, game)
+		}
 	}
 
 	// skip if poll service isn't running
 	if s.services.Poll != nil {
+		answers := make(map[string]*query.MasterQuery)
+
 		s.services.Poll.Lock()
-		for _, v := range s.services.Poll.PollMasterInfo.Errors {
-			errors = append(errors, v.Error())
+		// the first poll may not have finished yet
+		if info := s.services.Poll.PollMasterInfo; info != nil {
+			for _, v := range info.Errors {
+				errors = append(errors, v.Error())
+			}
+
+			for _, v := range info.Masters {
+				answers[v.Address] = v
+			}
 		}
+		s.services.Poll.Unlock()
 
-		for _, v := range s.services.Poll.PollMasterInfo.Masters {
+		// every known master is listed, including the failing ones the health is most useful for
+		for _, h := range s.services.Poll.MasterHealth() {
 			masters = append(masters, &MasterQuery{
-				MasterQuery: v,
-				ServerCount: len(v.Servers),
+				MasterQuery:         answers[h.Address],
+				Address:             h.Address,
+				ServerCount:         h.ServersContributed,
+				Attempts:            h.Attempts,
+				Successes:           h.Successes,
+				ConsecutiveFailures: h.ConsecutiveFailures,
+				LastSuccess:         h.LastSuccess,
+				AverageLatency:      h.AverageLatency(),
 			})
 		}
-		s.services.Poll.Unlock()
 	}
 
-	// skip if STUN service isn't running
-	if s.services.STUN != nil {
-		for _, v := range s.services.STUN.LocalAddresses {
-			localizedGames = make([]*query.PingInfoQuery, 0)
-
-			for _, game := range rawGames {
-				ipString, portString, _ := net.SplitHostPort(game.Address)
-				ip := net.ParseIP(ipString)
-
-				if ip != nil && v.Contains(ip) {
-					game.Address = v.IP.String() + ":" + portString
-				}
-
-				localizedGames = append(localizedGames, game)
-			}
-
-			// update the cache
-			data := &ServerListData{
-				RequestTime: time.Now(),
-				Masters:     masters,
-				Games:       localizedGames,
-				Errors:      errors,
-			}
+	sort.Sort(MastersByPing(masters))
 
-			sort.Sort(data.Masters)
-			sort.Sort(data.Games)
+	// keep the unlocalized list, every view rendered from the previous one is stale now
+	s.Lock()
+	s.cache[cacheMultiplayerServers] = &ServerListData{
+		RequestTime: time.Now(),
+		Masters:     masters,
+		Games:       rawGames,
+		Errors:      errors,
+	}
+	s.cache[cacheMultiplayer] = make(map[string]*CacheResponse)
+	s.Unlock()
 
-			jsonOut, err := json.Marshal(data)
-			if err != nil {
-				continue
-			}
+	return s.multiplayerServersView(nil, nil)
+}
 
-			CacheResponses[v.String()] = &CacheResponse{
-				Response: jsonOut,
-				Time:     data.RequestTime,
-			}
-		}
+// multiplayerServersView returns the server list as seen by a client, rendering it on first use.
+// clients on the same local network and inside the same nat rules share a view
+func (s *Service) multiplayerServersView(local *net.IPNet, client net.IP) (cacheData *CacheResponse) {
+	key := ""
+	if local != nil {
+		key = local.IP.String()
+	}
 
-		localizedGames = make([]*query.PingInfoQuery, 0)
+	if s.services.Master != nil {
+		key += "/" + s.services.Master.NATView(client)
+	}
 
-		for _, game := range rawGames {
-			addressString, portString, _ := net.SplitHostPort(game.Address)
+	s.Lock()
+	defer s.Unlock()
 
-			if ok, _ := s.services.STUN.IsInLocalNets(addressString); ok {
-				game.Address = s.services.STUN.Get("") + ":" + portString
-			}
+	views := s.cache[cacheMultiplayer].(map[string]*CacheResponse)
+	if cacheData, ok := views[key]; ok {
+		return cacheData
+	}
 
-			localizedGames = append(localizedGames, game)
-		}
+	raw, ok := s.cache[cacheMultiplayerServers].(*ServerListData)
+	if !ok {
+		return nil
 	}
 
-	// update the cache
 	data := &ServerListData{
-		RequestTime: time.Now(),
-		Masters:     masters,
-		Games:       localizedGames,
-		Errors:      errors,
+		RequestTime: raw.RequestTime,
+		Masters:     raw.Masters,
+		Games:       s.localizeGames(raw.Games, local, client),
+		Errors:      raw.Errors,
 	}
 
-	sort.Sort(data.Masters)
 	sort.Sort(data.Games)
 
 	jsonOut, err := json.Marshal(data)
 	if err != nil {
 		s.logs.HTTPD.LogAlertf("error marshalling api server list [%w]", err)
-		return
+		return nil
 	}
 
-	CacheResponses[""] = &CacheResponse{
+	cacheData = &CacheResponse{
 		Response: jsonOut,
 		Time:     data.RequestTime,
 	}
+	views[key] = cacheData
 
-	s.Lock()
-	s.cache[cacheMultiplayer] = CacheResponses
-	s.Unlock()
+	return cacheData
+}
+
+// localizeGames rewrites server addresses for a client. nat rules decide first, servers without a rule are shown
+// by their local address to clients on the same local network, and by the STUN address to everyone else
+func (s *Service) localizeGames(rawGames []*Game, local *net.IPNet, client net.IP) []*Game {
+	games := make([]*Game, 0, len(rawGames))
+
+	// skip if STUN service isn't running, or the external address isn't known
+	externalIP := ""
+	if s.services.STUN != nil {
+		externalIP = s.services.STUN.Get("")
+	}
+
+	for _, game := range rawGames {
+		addressString, portString, _ := net.SplitHostPort(game.Address)
+		ip := net.ParseIP(addressString)
+
+		var rewritten net.IP
+
+		matched := false
+		if ip != nil && s.services.Master != nil {
+			rewritten, matched = s.services.Master.RewriteNAT(ip, client)
+		}
+
+		switch {
+		case ip == nil:
+
+		case matched:
+			if !rewritten.Equal(ip) {
+				game = withAddress(game, net.JoinHostPort(rewritten.String(), portString))
+			}
+
+		case local != nil:
+			if local.Contains(ip) {
+				game = withAddress(game, net.JoinHostPort(local.IP.String(), portString))
+			}
+
+		case externalIP != "":
+			// the STUN address is IPv4, so only IPv4 servers can be rewritten to it
+			if ok, _ := s.services.STUN.IsInLocalNets(addressString); ok && master.IsIPv4Server(game.Address) {
+				game = withAddress(game, net.JoinHostPort(externalIP, portString))
+			}
+		}
+
+		games = append(games, game)
+	}
+
+	return games
+}
+
+// withAddress returns a copy of a game with its address replaced, leaving the shared original untouched
+func withAddress(game *Game, address string) *Game {
+	info := *game.PingInfo
+	info.Address = address
+
+	pingInfoQuery := *game.PingInfoQuery
+	pingInfoQuery.PingInfo = &info
+
+	out := *game
+	out.PingInfoQuery = &pingInfoQuery
+
+	return &out
+}
+
+// withName returns a copy of a game with a different server name, the original is shared with the master
+func withName(game *Game, name string) *Game {
+	info := *game.PingInfo
+	info.Name = []byte(name)
+
+	pingInfoQuery := *game.PingInfoQuery
+	pingInfoQuery.PingInfo = &info
 
-	cacheData = CacheResponses[""]
+	out := *game
+	out.PingInfoQuery = &pingInfoQuery
 
-	return
+	return &out
 }
 
 func (s *Service) clearThrottleCache() {
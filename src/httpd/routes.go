@@ -8,13 +8,20 @@ import (
 	"time"
 
 	"github.com/StarsiegePlayers/neos-thicc-master/src/config"
+	"github.com/StarsiegePlayers/neos-thicc-master/src/polling"
 )
 
 const HTTPStatusEnhanceYourCalm = 420
 
+type HTTPMasterConsistency struct {
+	*polling.ConsistencyReport
+	HTTPError
+}
+
 func (s *Service) registerRoutes() {
 	s.router.SetFileSystem(fs.Sub(s.services.Config.BuildInfo.EmbedFS, "www-build"))
 	s.router.AddRoute("/api/v1/master/info", http.MethodGet, http.HandlerFunc(s.routeGetMasterInfo))
+	s.router.AddRoute("/api/v1/master/consistency", http.MethodGet, http.HandlerFunc(s.routeGetMasterConsistency))
 	s.router.AddRoute("/api/v1/multiplayer/servers", http.MethodGet, http.HandlerFunc(s.routeGetMultiplayerServers))
 	s.router.AddRoute("/api/v1/admin/login", http.MethodGet, s.middlewareThrottle(s.routeGetAdminLogin))
 	s.router.AddRoute("/api/v1/admin/login", http.MethodPost, s.middlewareThrottle(s.routePostAdminLogin))
@@ -23,37 +30,82 @@ func (s *Service) registerRoutes() {
 	s.router.AddRoute("/api/v1/admin/serversettings", http.MethodPost, s.middlewareAuth(s.routePostAdminServerSettings))
 	s.router.AddRoute("/api/v1/admin/poweraction", http.MethodPost, s.middlewareAuth(s.routePostAdminPowerAction))
 	s.router.AddRoute("/api/v1/admin/services", http.MethodGet, s.middlewareAuth(s.routeGetAdminServiceStatus))
+	s.router.AddRoute("/api/v1/admin/master/stats", http.MethodGet, s.middlewareAuth(s.routeGetAdminMasterStats))
+	s.router.AddRoute("/api/v1/admin/bans", http.MethodGet, s.middlewareAuth(s.routeGetAdminBans))
+	s.router.AddRoute("/api/v1/admin/bans", http.MethodPost, s.middlewareAuth(s.routePostAdminBan))
+	s.router.AddRoute("/api/v1/admin/bans", http.MethodDelete, s.middlewareAuth(s.routeDeleteAdminBan))
+	s.router.AddRoute("/api/v1/admin/allowlist", http.MethodGet, s.middlewareAuth(s.routeGetAdminAllowlist))
+	s.router.AddRoute("/api/v1/admin/allowlist", http.MethodPost, s.middlewareAuth(s.routePostAdminAllowlist))
+	s.router.AddRoute("/api/v1/admin/flagged", http.MethodGet, s.middlewareAuth(s.routeGetAdminFlagged))
+	s.router.AddRoute("/api/v1/admin/flagged", http.MethodPost, s.middlewareAuth(s.routePostAdminFlaggedReview))
+	s.router.AddRoute("/api/v1/admin/poll", http.MethodGet, s.middlewareAuth(s.routeGetAdminPollHealth))
 	s.router.AddRoute("/yeet", http.MethodGet, http.HandlerFunc(s.routeGetYeeted))
 }
 
 func (s *Service) routeGetMultiplayerServers(w http.ResponseWriter, r *http.Request) {
-	cacheData := s.cache[cacheMultiplayer].(map[string]*CacheResponse)
-
-	data, ok := cacheData[""]
-	if !ok {
-		// if we don't have something in the cache, populate it.
-		data = s.maintenanceMultiplayerServersCache()
-	}
+	remoteIPString, _, _ := net.SplitHostPort(r.RemoteAddr)
+	client := net.ParseIP(remoteIPString)
 
-	remoteIPString, _, err := net.SplitHostPort(r.RemoteAddr)
+	var local *net.IPNet
 
 	// skip if STUN service isn't running
-	if s.services.STUN != nil && err == nil {
-		if ok, ip := s.services.STUN.IsInLocalNets(remoteIPString); ok {
-			if d2, ok2 := cacheData[ip.String()]; ok2 {
-				data = d2
+	if s.services.STUN != nil && client != nil {
+		for _, v := range s.services.STUN.LocalAddresses() {
+			if v.Contains(client) {
+				local = v
+				break
 			}
-		} else {
-
 		}
 	}
 
+	s.Lock()
+	_, ok := s.cache[cacheMultiplayerServers]
+	s.Unlock()
+
+	if !ok {
+		// if we don't have something in the cache, populate it.
+		s.maintenanceMultiplayerServersCache()
+	}
+
+	data := s.multiplayerServersView(local, client)
+	if data == nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Add("Content-Type", "application/json")
 	w.Header().Add("Last-Modified", data.Time.Format(time.RFC1123))
 
 	_, _ = w.Write(data.Response)
 }
 
+func (s *Service) routeGetMasterConsistency(w http.ResponseWriter, _ *http.Request) {
+	// skip if poll service isn't running
+	if s.services.Poll == nil {
+		s.router.jsonOut(w, HTTPError{
+			Error:     "polling is disabled",
+			ErrorCode: http.StatusServiceUnavailable,
+		})
+
+		return
+	}
+
+	report := s.services.Poll.ConsistencyReport()
+	if report == nil {
+		s.router.jsonOut(w, HTTPError{
+			Error:     "no poll has finished yet",
+			ErrorCode: http.StatusServiceUnavailable,
+		})
+
+		return
+	}
+
+	s.router.jsonOut(w, HTTPMasterConsistency{
+		ConsistencyReport: report,
+		HTTPError:         HTTPError{},
+	})
+}
+
 func (s *Service) routeGetMasterInfo(w http.ResponseWriter, r *http.Request) {
 	hostname := s.services.Config.Values.Service.Hostname
 	if hostname == "" {
@@ -1,8 +1,10 @@
 package httpd
 
 import (
+	"encoding/json"
 	"time"
 
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/protocol"
 	"github.com/StarsiegePlayers/darkstar-query-go/v2/query"
 )
 
@@ -14,18 +16,85 @@ type CacheResponse struct {
 type ServerListData struct {
 	RequestTime time.Time
 	Masters     MastersByPing
-	Games       PingInfoQueryByPing
+	Games       GamesByPing
 	Errors      []string
 }
 
 type MastersByPing []*MasterQuery
 
-func (m MastersByPing) Len() int           { return len(m) }
-func (m MastersByPing) Less(i, j int) bool { return m[i].Ping < m[j].Ping }
-func (m MastersByPing) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m MastersByPing) Len() int      { return len(m) }
+func (m MastersByPing) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
 
-type PingInfoQueryByPing []*query.PingInfoQuery
+// Less sorts masters that answered by ping, masters without an answer come last
+func (m MastersByPing) Less(i, j int) bool {
+	if m[i].MasterQuery == nil || m[j].MasterQuery == nil {
+		return m[i].MasterQuery != nil && m[j].MasterQuery == nil
+	}
 
-func (p PingInfoQueryByPing) Len() int           { return len(p) }
-func (p PingInfoQueryByPing) Less(i, j int) bool { return p[i].Ping < p[j].Ping }
-func (p PingInfoQueryByPing) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+	return m[i].Ping < m[j].Ping
+}
+
+// Game is a server's last ping response along with the master's view of its health
+type Game struct {
+	*query.PingInfoQuery
+	Stale        bool
+	Featured     bool
+	Origin       string
+	OriginMaster string
+}
+
+// gameJSON is the json form of a Game, the ping info fields are copied as its own marshaller would hide the rest
+type gameJSON struct {
+	GameMode     byte
+	PlayerCount  byte
+	MaxPlayers   byte
+	GameStatus   protocol.StatusByte
+	GameName     string
+	GameVersion  string
+	Name         string
+	Address      string
+	Ping         time.Duration
+	Stale        bool
+	Featured     bool
+	Origin       string
+	OriginMaster string
+}
+
+// MarshalJSON writes the ping info along with the health and provenance fields, a server without ping info
+// is written with empty ping info fields
+func (g *Game) MarshalJSON() ([]byte, error) {
+	out := gameJSON{
+		Stale:        g.Stale,
+		Featured:     g.Featured,
+		Origin:       g.Origin,
+		OriginMaster: g.OriginMaster,
+	}
+
+	if g.PingInfoQuery != nil && g.PingInfo != nil {
+		out.GameMode = g.GameMode
+		out.PlayerCount = g.PlayerCount
+		out.MaxPlayers = g.MaxPlayers
+		out.GameStatus = g.GameStatus
+		out.GameName = string(g.GameName)
+		out.GameVersion = string(g.GameVersion)
+		out.Name = string(g.Name)
+		out.Address = g.Address
+		out.Ping = g.Ping
+	}
+
+	return json.Marshal(out)
+}
+
+type GamesByPing []*Game
+
+func (p GamesByPing) Len() int      { return len(p) }
+func (p GamesByPing) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+
+func (p GamesByPing) Less(i, j int) bool {
+	// featured servers are always listed first
+	if p[i].Featured != p[j].Featured {
+		return p[i].Featured
+	}
+
+	return p[i].Ping < p[j].Ping
+}
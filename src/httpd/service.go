@@ -3,8 +3,9 @@ package httpd
 import (
 	"context"
 	"errors"
-	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -42,6 +43,7 @@ type Service struct {
 	service.Interface
 	service.Runnable
 	service.Maintainable
+	service.Localizable
 }
 
 const (
@@ -84,7 +86,7 @@ func (s *Service) newServer() (out *http.Server) {
 		s.listenPort = s.services.Config.Values.Service.Listen.Port
 	}
 
-	ipPort := fmt.Sprintf("%s:%d", s.listenIP, s.listenPort)
+	ipPort := net.JoinHostPort(s.listenIP, strconv.Itoa(int(s.listenPort)))
 	out = &http.Server{
 		Addr:    ipPort,
 		Handler: s.router.Mux(),
@@ -97,6 +99,13 @@ func (s *Service) Status() service.LifeCycle {
 	return s.status
 }
 
+// Relocalize drops the server list views, they are rendered again using the new addresses
+func (s *Service) Relocalize() {
+	s.Lock()
+	s.cache[cacheMultiplayer] = make(map[string]*CacheResponse)
+	s.Unlock()
+}
+
 func (s *Service) Maintenance() {
 	s.maintenanceMultiplayerServersCache()
 	s.clearThrottleCache()
@@ -110,10 +119,15 @@ func (s *Service) Run() {
 		ip = service.LocalhostAddress
 	}
 
-	localIPPort := fmt.Sprintf("%s:%d", ip, s.listenPort)
-	externalIPPort := fmt.Sprintf("%s:%d", s.services.STUN.Get(""), s.listenPort)
+	localIPPort := net.JoinHostPort(ip, strconv.Itoa(int(s.listenPort)))
 
-	s.logs.HTTPD.Logf("now listening on http://%s/ | http://%s/", externalIPPort, localIPPort)
+	// the external address may not be known yet
+	if externalIP := s.services.STUN.Get(""); externalIP != "" {
+		externalIPPort := net.JoinHostPort(externalIP, strconv.Itoa(int(s.listenPort)))
+		s.logs.HTTPD.Logf("now listening on http://%s/ | http://%s/", externalIPPort, localIPPort)
+	} else {
+		s.logs.HTTPD.Logf("now listening on http://%s/", localIPPort)
+	}
 
 	s.status = service.Running
 	if err := s.srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
@@ -28,6 +28,13 @@ func (l *Log) serverColor(input string) uint8 {
 	return (((o % 36) * 36) + (o % 6) + 16) % 255 //nolint:gomnd
 }
 
+// Enabled checks if the component of this logger is in the list of logged components
+func (l *Log) Enabled() bool {
+	key, ok := l.logService.log.categories.Load(l.ID)
+
+	return ok && key.(bool)
+}
+
 func (l *Log) Logf(format string, args ...interface{}) {
 	if key, ok := l.logService.log.categories.Load(l.ID); !ok || !key.(bool) {
 		return
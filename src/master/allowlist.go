@@ -0,0 +1,279 @@
+package master
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+)
+
+const (
+	// maxPendingServers caps the pending list, heartbeats are unauthenticated and easily spoofed
+	maxPendingServers = 256
+
+	// pendingServerTTL is how long a server stays pending after its last rejected heartbeat
+	pendingServerTTL = time.Hour
+)
+
+var (
+	ErrInvalidServerAddress = errors.New("invalid server address, expected ip:port")
+	ErrServerNotPending     = errors.New("server is not pending approval")
+)
+
+type allowlist struct {
+	sync.Mutex
+
+	enabled  bool
+	servers  map[string]bool
+	networks []*net.IPNet
+	pending  map[string]*PendingServer
+}
+
+type PendingServer struct {
+	Address    string
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	Heartbeats int
+}
+
+type AllowlistStats struct {
+	Enabled bool
+	Entries int
+	Pending []PendingServer
+}
+
+// normalizeServerAddress parses an ip:port pair into the identifier the master uses for servers
+func normalizeServerAddress(input string) (string, error) {
+	host, port, err := net.SplitHostPort(strings.TrimSpace(input))
+	if err != nil {
+		return "", ErrInvalidServerAddress
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", ErrInvalidServerAddress
+	}
+
+	portNumber, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || portNumber == 0 {
+		return "", ErrInvalidServerAddress
+	}
+
+	addr := normalizeUDPAddr(&net.UDPAddr{IP: ip, Port: int(portNumber)})
+
+	return addr.String(), nil
+}
+
+// rehashAllowlist parses the configured allowlist and removes listed servers that are no longer allowed
+func (s *Service) rehashAllowlist() {
+	cfg := &s.services.Config.Values.Service.Allowlist
+	a := &s.allowlist
+
+	servers := make(map[string]bool)
+	networks := make([]*net.IPNet, 0)
+
+	for _, v := range cfg.Servers {
+		// entries are either a single server, or an ip address or CIDR network covering every port
+		if ipPort, err := normalizeServerAddress(v); err == nil {
+			servers[ipPort] = true
+			continue
+		}
+
+		network, err := parseNetwork(v)
+		if err != nil {
+			s.logs.Registration.LogAlertf("{%s} unable to parse allowlist entry %s", service.Rehash, v)
+			continue
+		}
+
+		networks = append(networks, network)
+	}
+
+	a.Lock()
+	a.enabled = cfg.Enabled
+	a.servers = servers
+	a.networks = networks
+
+	if a.pending == nil {
+		a.pending = make(map[string]*PendingServer)
+	}
+
+	for k := range a.pending {
+		if !a.enabled || a.allows(k) {
+			delete(a.pending, k)
+		}
+	}
+	a.Unlock()
+
+	if !cfg.Enabled {
+		return
+	}
+
+	s.logs.Registration.Logf("{%s} allowlist mode enabled with %d servers and %d networks", service.Rehash, len(servers), len(networks))
+
+	for ipPort, v := range s.Snapshot().Servers {
+		if !v.Pinned && !s.isAllowlisted(ipPort) {
+			s.deleteServer(ipPort)
+			s.logs.Registration.ServerAlertf(ipPort, "{%s} removed, server is not on the allowlist", service.Rehash)
+		}
+	}
+}
+
+// allows checks a server against the allowlist entries, it expects the allowlist lock to be held
+func (a *allowlist) allows(ipPort string) bool {
+	if a.servers[ipPort] {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(ipPort)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, v := range a.networks {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAllowlisted checks if a server may be registered, every server is allowed unless allowlist mode is enabled.
+// pinned servers are always allowed
+func (s *Service) isAllowlisted(ipPort string) bool {
+	a := &s.allowlist
+
+	a.Lock()
+	allowed := !a.enabled || a.allows(ipPort)
+	a.Unlock()
+
+	return allowed || s.isPinned(ipPort)
+}
+
+// rejectHeartbeat records a server that isn't on the allowlist so an admin can approve it
+func (s *Service) rejectHeartbeat(ipPort string) {
+	a := &s.allowlist
+	now := time.Now()
+
+	a.Lock()
+	p, ok := a.pending[ipPort]
+
+	if !ok && len(a.pending) < maxPendingServers {
+		p = &PendingServer{
+			Address:   ipPort,
+			FirstSeen: now,
+		}
+		a.pending[ipPort] = p
+	}
+
+	heartbeats := 0
+	if p != nil {
+		p.LastSeen = now
+		p.Heartbeats++
+		heartbeats = p.Heartbeats
+	}
+	a.Unlock()
+
+	if heartbeats == 0 {
+		s.logs.Registration.ServerAlertf(ipPort, "Rejecting heartbeat from server not on the allowlist, pending list is full [%d]", maxPendingServers)
+		return
+	}
+
+	s.logs.Registration.ServerAlertf(ipPort, "Rejecting heartbeat from server not on the allowlist, awaiting approval [heartbeats: %d]", heartbeats)
+}
+
+// ApproveServer adds a server to the in-memory allowlist and verifies it straight away,
+// persisting the entry to the configuration is up to the caller
+func (s *Service) ApproveServer(address string, approvedBy string) (string, error) {
+	ipPort, err := normalizeServerAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	a := &s.allowlist
+
+	a.Lock()
+	if a.servers == nil {
+		a.servers = make(map[string]bool)
+	}
+
+	a.servers[ipPort] = true
+	delete(a.pending, ipPort)
+	a.Unlock()
+
+	s.logs.Registration.ServerLogf(ipPort, "added to the allowlist by %s", approvedBy)
+
+	return ipPort, s.RegisterExternalServer(ipPort)
+}
+
+// DismissPendingServer removes a server from the pending list without approving it
+func (s *Service) DismissPendingServer(address string, dismissedBy string) error {
+	ipPort, err := normalizeServerAddress(address)
+	if err != nil {
+		return err
+	}
+
+	a := &s.allowlist
+
+	a.Lock()
+	_, ok := a.pending[ipPort]
+	delete(a.pending, ipPort)
+	a.Unlock()
+
+	if !ok {
+		return ErrServerNotPending
+	}
+
+	s.logs.Registration.ServerLogf(ipPort, "dismissed from the pending list by %s", dismissedBy)
+
+	return nil
+}
+
+// maintainAllowlist forgets pending servers that stopped sending heartbeats
+func (s *Service) maintainAllowlist() {
+	a := &s.allowlist
+	now := time.Now()
+
+	a.Lock()
+	defer a.Unlock()
+
+	for k, v := range a.pending {
+		if now.Sub(v.LastSeen) > pendingServerTTL {
+			delete(a.pending, k)
+		}
+	}
+}
+
+// AllowlistStats returns the allowlist state and the servers awaiting approval
+func (s *Service) AllowlistStats() AllowlistStats {
+	a := &s.allowlist
+
+	a.Lock()
+	defer a.Unlock()
+
+	out := AllowlistStats{
+		Enabled: a.enabled,
+		Entries: len(a.servers) + len(a.networks),
+		Pending: make([]PendingServer, 0, len(a.pending)),
+	}
+
+	for _, v := range a.pending {
+		out.Pending = append(out.Pending, *v)
+	}
+
+	sort.Slice(out.Pending, func(i, j int) bool {
+		return out.Pending[i].FirstSeen.Before(out.Pending[j].FirstSeen)
+	})
+
+	return out
+}
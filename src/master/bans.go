@@ -0,0 +1,239 @@
+package master
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service/file"
+)
+
+const banIDLength = 8
+
+var ErrBanNotFound = errors.New("ban not found")
+
+type Ban struct {
+	ID      string
+	CIDR    string
+	Reason  string
+	AddedBy string
+	Created time.Time
+	Expires time.Time
+	MOTD    string `json:",omitempty"`
+
+	network *net.IPNet
+}
+
+// IsExpired checks if a ban has run out, bans without an expiry never do
+func (b *Ban) IsExpired(now time.Time) bool {
+	return !b.Expires.IsZero() && now.After(b.Expires)
+}
+
+type banStore struct {
+	sync.Mutex
+
+	bans map[string]*Ban
+}
+
+type banFile struct {
+	Saved time.Time
+	Bans  []*Ban
+}
+
+// loadBans merges the configured ban file into the ban store, entries in the file replace bans with the same id.
+// bans only held in memory, such as ones added since the file was last written, are kept
+func (s *Service) loadBans() error {
+	fileName := s.services.Config.Values.Service.Banned.File
+	if fileName == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	in := banFile{}
+
+	err = json.Unmarshal(data, &in)
+	if err != nil {
+		return err
+	}
+
+	loaded := 0
+
+	s.bans.Lock()
+	for _, v := range in.Bans {
+		v.network, err = parseNetwork(v.CIDR)
+		if err != nil {
+			s.logs.Banned.LogAlertf("unable to parse ban %s for %s [%s]", v.ID, v.CIDR, err)
+			continue
+		}
+
+		s.bans.bans[v.ID] = v
+		loaded++
+	}
+	total := len(s.bans.bans)
+	s.bans.Unlock()
+
+	s.logs.Banned.Logf("loaded %d bans from %s, %d bans in the store", loaded, fileName, total)
+
+	return nil
+}
+
+// saveBans writes the ban store to the configured ban file, it expects the ban store lock to be held
+func (s *Service) saveBans() error {
+	fileName := s.services.Config.Values.Service.Banned.File
+	if fileName == "" {
+		return nil
+	}
+
+	out := banFile{
+		Saved: time.Now(),
+		Bans:  s.sortedBans(),
+	}
+
+	data, err := json.MarshalIndent(out, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return file.WriteAtomic(fileName, data, file.UserReadWrite|file.GroupRead|file.OtherRead)
+}
+
+// sortedBans returns the bans oldest first, it expects the ban store lock to be held
+func (s *Service) sortedBans() []*Ban {
+	out := make([]*Ban, 0, len(s.bans.bans))
+	for _, v := range s.bans.bans {
+		out = append(out, v)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Created.Before(out[j].Created)
+	})
+
+	return out
+}
+
+// findBan returns the active ban covering an ip address, if any
+func (s *Service) findBan(ip net.IP) *Ban {
+	now := time.Now()
+
+	s.bans.Lock()
+	defer s.bans.Unlock()
+
+	for _, v := range s.bans.bans {
+		if !v.IsExpired(now) && v.network.Contains(ip) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// Bans returns a copy of every ban in the store
+func (s *Service) Bans() (out []Ban) {
+	s.bans.Lock()
+	defer s.bans.Unlock()
+
+	out = make([]Ban, 0, len(s.bans.bans))
+	for _, v := range s.sortedBans() {
+		out = append(out, *v)
+	}
+
+	return
+}
+
+// AddBan validates and stores a new ban, the ID and creation time are filled in by the store
+func (s *Service) AddBan(ban Ban) (Ban, error) {
+	network, err := parseNetwork(ban.CIDR)
+	if err != nil {
+		return Ban{}, err
+	}
+
+	id := make([]byte, banIDLength)
+	if _, err = rand.Read(id); err != nil {
+		return Ban{}, err
+	}
+
+	ban.ID = hex.EncodeToString(id)
+	ban.CIDR = network.String()
+	ban.Created = time.Now()
+	ban.network = network
+
+	s.bans.Lock()
+	defer s.bans.Unlock()
+
+	if s.bans.bans == nil {
+		s.bans.bans = make(map[string]*Ban)
+	}
+
+	s.bans.bans[ban.ID] = &ban
+
+	s.logs.Banned.ServerLogf(ban.CIDR, "banned by %s until %s [%s]", ban.AddedBy, banExpiry(&ban), ban.Reason)
+
+	return ban, s.saveBans()
+}
+
+// RemoveBan deletes a ban from the store
+func (s *Service) RemoveBan(id string, removedBy string) error {
+	s.bans.Lock()
+	defer s.bans.Unlock()
+
+	ban, ok := s.bans.bans[id]
+	if !ok {
+		return ErrBanNotFound
+	}
+
+	delete(s.bans.bans, id)
+
+	s.logs.Banned.ServerLogf(ban.CIDR, "ban removed by %s [%s]", removedBy, ban.Reason)
+
+	return s.saveBans()
+}
+
+// maintainBans removes expired bans from the store
+func (s *Service) maintainBans() {
+	now := time.Now()
+
+	s.bans.Lock()
+	defer s.bans.Unlock()
+
+	removed := 0
+
+	for k, v := range s.bans.bans {
+		if v.IsExpired(now) {
+			s.logs.Banned.ServerLogf(v.CIDR, "{%s} ban expired [%s]", service.Maintenance, v.Reason)
+			delete(s.bans.bans, k)
+
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return
+	}
+
+	if err := s.saveBans(); err != nil {
+		s.logs.Banned.LogAlertf("{%s} unable to save ban file [%s]", service.Maintenance, err)
+	}
+}
+
+func banExpiry(ban *Ban) string {
+	if ban.Expires.IsZero() {
+		return "forever"
+	}
+
+	return ban.Expires.Format(time.Stamp)
+}
@@ -0,0 +1,215 @@
+package master
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/protocol"
+)
+
+// cookieLength matches the 10 MOTD padding characters stock clients ignore
+const cookieLength = 10
+
+type budgetEntry struct {
+	window int64
+	used   int
+}
+
+type responseBudget struct {
+	sync.Mutex
+
+	secret  []byte
+	entries map[string]*budgetEntry
+
+	counters struct {
+		requestBytes     uint64
+		responseBytes    uint64
+		overBudget       uint64
+		challengesSent   uint64
+		challengesPassed uint64
+	}
+}
+
+type ResponseBudgetStats struct {
+	Enabled            bool
+	Tracked            int
+	RequestBytes       uint64
+	ResponseBytes      uint64
+	AmplificationRatio float64
+	OverBudget         uint64
+	ChallengesSent     uint64
+	ChallengesPassed   uint64
+}
+
+// initResponseBudget generates the secret used to sign challenge cookies
+func (s *Service) initResponseBudget() {
+	b := &s.responseBudget
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.entries = make(map[string]*budgetEntry)
+	b.secret = make([]byte, sha256.Size)
+
+	if _, err := rand.Read(b.secret); err != nil {
+		s.logs.Master.LogAlertf("unable to generate challenge secret [%s]", err)
+	}
+}
+
+// budgetWindow returns the index of the current budget window
+func (s *Service) budgetWindow(now time.Time) int64 {
+	window := s.services.Config.Values.Service.ResponseBudget.Window.Duration
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	return now.UnixNano() / int64(window)
+}
+
+// challengeCookie signs the host and budget window into a printable cookie
+func (s *Service) challengeCookie(host string, window int64) string {
+	w := make([]byte, 8) //nolint:gomnd
+	binary.BigEndian.PutUint64(w, uint64(window))
+
+	mac := hmac.New(sha256.New, s.responseBudget.secret)
+	_, _ = mac.Write([]byte(host))
+	_, _ = mac.Write(w)
+
+	return hex.EncodeToString(mac.Sum(nil))[:cookieLength]
+}
+
+// hasValidCookie checks if a request echoes the cookie issued for this or the previous window
+func (s *Service) hasValidCookie(host string, p *protocol.Packet, window int64) bool {
+	if len(p.Data) < cookieLength {
+		return false
+	}
+
+	for _, w := range []int64{window, window - 1} {
+		if hmac.Equal(p.Data[:cookieLength], []byte(s.challengeCookie(host, w))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyResponseBudget builds a server list response and charges it against the budget of its destination,
+// returning the packets that should actually be sent
+func (s *Service) applyResponseBudget(host string, p *protocol.Packet, snap *Snapshot, motd string, entries []byte) [][]byte {
+	output := s.generateList(snap, motd, dummythicc, entries, p.Key)
+
+	cfg := &s.services.Config.Values.Service.ResponseBudget
+	if !cfg.Enabled {
+		return output
+	}
+
+	size := 0
+	for _, v := range output {
+		size += len(v)
+	}
+
+	now := time.Now()
+	window := s.budgetWindow(now)
+	b := &s.responseBudget
+
+	b.Lock()
+	e, ok := b.entries[host]
+
+	if !ok || e.window != window {
+		e = &budgetEntry{window: window}
+		b.entries[host] = e
+	}
+
+	if e.used+size <= cfg.BytesPerWindow {
+		e.used += size
+		b.Unlock()
+
+		return output
+	}
+
+	b.counters.overBudget++
+	b.Unlock()
+
+	if !cfg.Challenge {
+		s.logs.Master.ServerAlertf(host, "response budget exceeded, dropping server list request")
+		return nil
+	}
+
+	// the cookie proves the source address isn't spoofed, so the budget no longer applies
+	passed := s.hasValidCookie(host, p, window)
+
+	b.Lock()
+	if passed {
+		b.counters.challengesPassed++
+	} else {
+		b.counters.challengesSent++
+	}
+	b.Unlock()
+
+	if passed {
+		return output
+	}
+
+	s.logs.Master.ServerAlertf(host, "response budget exceeded, sending a single packet list with a challenge")
+
+	// stock clients can't answer the challenge, so they still get a real list, cut down to a single packet
+	// which keeps the response no bigger than a list with few servers
+	cookie := s.challengeCookie(host, window)
+
+	return s.generateList(snap, motd, cookie, firstPacketEntries(snap, motd, cookie, entries), p.Key)
+}
+
+// recordAmplification tracks request and response sizes for the amplification ratio
+func (s *Service) recordAmplification(requestSize int, output [][]byte) {
+	responseSize := 0
+	for _, v := range output {
+		responseSize += len(v)
+	}
+
+	s.responseBudget.Lock()
+	s.responseBudget.counters.requestBytes += uint64(requestSize)
+	s.responseBudget.counters.responseBytes += uint64(responseSize)
+	s.responseBudget.Unlock()
+}
+
+// maintainResponseBudget drops budget entries from past windows
+func (s *Service) maintainResponseBudget() {
+	window := s.budgetWindow(time.Now())
+	b := &s.responseBudget
+
+	b.Lock()
+	for k, v := range b.entries {
+		if v.window != window {
+			delete(b.entries, k)
+		}
+	}
+	b.Unlock()
+}
+
+// ResponseBudgetStats returns the current state of the response budget and amplification counters
+func (s *Service) ResponseBudgetStats() ResponseBudgetStats {
+	b := &s.responseBudget
+
+	b.Lock()
+	out := ResponseBudgetStats{
+		Enabled:          s.services.Config.Values.Service.ResponseBudget.Enabled,
+		Tracked:          len(b.entries),
+		RequestBytes:     b.counters.requestBytes,
+		ResponseBytes:    b.counters.responseBytes,
+		OverBudget:       b.counters.overBudget,
+		ChallengesSent:   b.counters.challengesSent,
+		ChallengesPassed: b.counters.challengesPassed,
+	}
+	b.Unlock()
+
+	if out.RequestBytes > 0 {
+		out.AmplificationRatio = float64(out.ResponseBytes) / float64(out.RequestBytes)
+	}
+
+	return out
+}
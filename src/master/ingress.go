@@ -0,0 +1,312 @@
+package master
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+)
+
+// maxDedupeEntries is the size at which the duplicate cache is pruned outside of regular maintenance
+const maxDedupeEntries = 65536
+
+type ingressPacket struct {
+	conn net.PacketConn
+	addr net.Addr
+	buf  *[]byte
+	n    int
+}
+
+type dedupeKey struct {
+	source string
+	hash   uint64
+}
+
+type ingress struct {
+	sync.Mutex
+
+	queue      chan *ingressPacket
+	quit       chan struct{}
+	wg         sync.WaitGroup
+	workers    int
+	queueSize  int
+	bufferSize int
+	buffers    sync.Pool // holds *[]byte so putting a buffer back doesn't allocate
+
+	dedupe struct {
+		sync.Mutex
+		window  time.Duration
+		entries map[dedupeKey]time.Time
+	}
+
+	counters struct {
+		received   uint64
+		queued     uint64
+		processed  uint64
+		dropped    uint64
+		duplicates uint64
+		stun       uint64
+	}
+	reportedDrops uint64
+}
+
+type IngressStats struct {
+	Workers       int
+	QueueSize     int
+	QueueDepth    int
+	DedupeEntries int
+	Received      uint64
+	Queued        uint64
+	Processed     uint64
+	Dropped       uint64
+	Duplicates    uint64
+	STUNResponses uint64
+}
+
+// startIngress spins up the packet handler pool using the current config values
+func (s *Service) startIngress() {
+	in := &s.ingress
+
+	in.Lock()
+	defer in.Unlock()
+
+	if in.quit != nil {
+		return
+	}
+
+	in.workers = s.services.Config.Values.Advanced.Ingress.Workers
+	if in.workers <= 0 {
+		in.workers = 1
+	}
+
+	in.queueSize = s.services.Config.Values.Advanced.Ingress.QueueSize
+	if in.queueSize <= 0 {
+		in.queueSize = 1
+	}
+
+	in.bufferSize = int(s.services.Config.Values.Advanced.Network.MaxPacketSize)
+
+	in.dedupe.Lock()
+	in.dedupe.window = s.services.Config.Values.Advanced.Ingress.DedupeWindow.Duration
+	if in.dedupe.entries == nil {
+		in.dedupe.entries = make(map[dedupeKey]time.Time)
+	}
+	in.dedupe.Unlock()
+
+	in.queue = make(chan *ingressPacket, in.queueSize)
+	in.quit = make(chan struct{})
+
+	for i := 0; i < in.workers; i++ {
+		in.wg.Add(1)
+
+		go s.packetWorker(in.queue, in.quit)
+	}
+
+	s.logs.Master.Logf("started %d packet handlers, queue size %d", in.workers, in.queueSize)
+}
+
+// stopIngress stops the packet handler pool, any packets still queued are discarded
+func (s *Service) stopIngress() {
+	in := &s.ingress
+
+	in.Lock()
+	if in.quit == nil {
+		in.Unlock()
+		return
+	}
+
+	close(in.quit)
+	in.quit = nil
+	in.queue = nil
+	in.Unlock()
+
+	in.wg.Wait()
+}
+
+// rehashIngress restarts the packet handler pool if its configuration has changed
+func (s *Service) rehashIngress() {
+	in := &s.ingress
+
+	in.Lock()
+	running := in.quit != nil
+	changed := in.workers != s.services.Config.Values.Advanced.Ingress.Workers ||
+		in.queueSize != s.services.Config.Values.Advanced.Ingress.QueueSize ||
+		in.bufferSize != int(s.services.Config.Values.Advanced.Network.MaxPacketSize)
+	in.Unlock()
+
+	in.dedupe.Lock()
+	in.dedupe.window = s.services.Config.Values.Advanced.Ingress.DedupeWindow.Duration
+	in.dedupe.Unlock()
+
+	if running && changed {
+		s.stopIngress()
+		s.startIngress()
+	}
+}
+
+// getBuffer returns a read buffer owned by the caller until it is passed to releaseBuffer
+func (s *Service) getBuffer() *[]byte {
+	in := &s.ingress
+
+	in.Lock()
+	size := in.bufferSize
+	in.Unlock()
+
+	if buf, ok := in.buffers.Get().(*[]byte); ok && len(*buf) == size {
+		return buf
+	}
+
+	buf := make([]byte, size)
+
+	return &buf
+}
+
+func (s *Service) releaseBuffer(buf *[]byte) {
+	s.ingress.buffers.Put(buf)
+}
+
+// enqueuePacket hands a packet and its buffer over to the handler pool, or sheds it if the queue is full
+func (s *Service) enqueuePacket(conn net.PacketConn, addr net.Addr, buf *[]byte, n int) {
+	in := &s.ingress
+	duplicate := s.isDuplicatePacket(addr, (*buf)[:n])
+
+	in.Lock()
+	defer in.Unlock()
+
+	in.counters.received++
+
+	if duplicate {
+		in.counters.duplicates++
+		s.releaseBuffer(buf)
+
+		return
+	}
+
+	select {
+	case in.queue <- &ingressPacket{conn: conn, addr: addr, buf: buf, n: n}:
+		in.counters.queued++
+
+	default:
+		in.counters.dropped++
+		s.releaseBuffer(buf)
+	}
+}
+
+func (s *Service) packetWorker(queue chan *ingressPacket, quit chan struct{}) {
+	defer s.ingress.wg.Done()
+
+	for {
+		select {
+		case <-quit:
+			return
+
+		case p := <-queue:
+			// STUN binding requests share the master sockets with darkstar packets
+			payload := (*p.buf)[:p.n]
+
+			if s.isSTUNPacket(payload) {
+				s.serveSTUN(p.conn, p.addr, payload)
+			} else {
+				s.serveMaster(p.conn, &p.addr, payload)
+			}
+			s.releaseBuffer(p.buf)
+
+			s.ingress.Lock()
+			s.ingress.counters.processed++
+			s.ingress.Unlock()
+		}
+	}
+}
+
+// isDuplicatePacket reports whether the same payload was received from the same source within the dedupe window
+func (s *Service) isDuplicatePacket(addr net.Addr, payload []byte) bool {
+	d := &s.ingress.dedupe
+
+	h := fnv.New64a()
+	_, _ = h.Write(payload)
+	key := dedupeKey{
+		source: addr.String(),
+		hash:   h.Sum64(),
+	}
+
+	now := time.Now()
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.window <= 0 {
+		return false
+	}
+
+	if seen, ok := d.entries[key]; ok && now.Sub(seen) < d.window {
+		return true
+	}
+
+	if len(d.entries) >= maxDedupeEntries {
+		s.pruneDedupeEntries(now)
+	}
+
+	d.entries[key] = now
+
+	return false
+}
+
+// pruneDedupeCache removes expired entries from the duplicate packet cache
+func (s *Service) pruneDedupeCache() {
+	s.ingress.dedupe.Lock()
+	s.pruneDedupeEntries(time.Now())
+	s.ingress.dedupe.Unlock()
+}
+
+// pruneDedupeEntries expects the dedupe lock to be held
+func (s *Service) pruneDedupeEntries(now time.Time) {
+	d := &s.ingress.dedupe
+
+	for k, v := range d.entries {
+		if now.Sub(v) >= d.window {
+			delete(d.entries, k)
+		}
+	}
+}
+
+// maintainIngress prunes the duplicate cache and reports packets shed since the last run
+func (s *Service) maintainIngress() {
+	s.pruneDedupeCache()
+
+	s.ingress.Lock()
+	dropped, previous := s.ingress.counters.dropped, s.ingress.reportedDrops
+	s.ingress.reportedDrops = dropped
+	s.ingress.Unlock()
+
+	if dropped > previous {
+		s.logs.Master.LogAlertf("{%s} ingress queue full, dropped %d packets since last run", service.Maintenance, dropped-previous)
+	}
+}
+
+// IngressStats returns the current counters of the packet ingress pipeline
+func (s *Service) IngressStats() IngressStats {
+	in := &s.ingress
+
+	in.Lock()
+	out := IngressStats{
+		Workers:    in.workers,
+		QueueSize:  in.queueSize,
+		QueueDepth: len(in.queue),
+		Received:   in.counters.received,
+		Queued:     in.counters.queued,
+		Processed:  in.counters.processed,
+		Dropped:    in.counters.dropped,
+		Duplicates: in.counters.duplicates,
+
+		STUNResponses: in.counters.stun,
+	}
+	in.Unlock()
+
+	in.dedupe.Lock()
+	out.DedupeEntries = len(in.dedupe.entries)
+	in.dedupe.Unlock()
+
+	return out
+}
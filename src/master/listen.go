@@ -0,0 +1,208 @@
+package master
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type listener struct {
+	endpoint string
+	conn     net.PacketConn
+}
+
+type listeners struct {
+	sync.Mutex
+
+	open map[string]*listener
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+type ListenerStats struct {
+	Endpoint string
+	Address  string
+}
+
+// listenEndpoints returns the configured listen addresses, the primary listen ip and port always comes first
+func (s *Service) listenEndpoints() (out []string) {
+	listen := &s.services.Config.Values.Service.Listen
+
+	// an empty listen ip binds every IPv4 and IPv6 address
+	primary := net.JoinHostPort(listen.IP, strconv.Itoa(int(listen.Port)))
+	out = append(out, primary)
+
+	seen := map[string]bool{
+		primary: true,
+	}
+
+	for _, v := range listen.Additional {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+
+		seen[v] = true
+		out = append(out, v)
+	}
+
+	return
+}
+
+// startListeners opens every configured socket, the returned channel is closed once the listeners are shut down.
+// opened is the number of sockets that could be bound
+func (s *Service) startListeners() (quit chan struct{}, opened int) {
+	l := &s.listeners
+
+	l.Lock()
+	if l.quit == nil {
+		l.quit = make(chan struct{})
+		l.open = make(map[string]*listener)
+	}
+	quit = l.quit
+	l.Unlock()
+
+	return quit, s.syncListeners()
+}
+
+// syncListeners opens sockets for new listen endpoints and closes the ones that were removed,
+// sockets for unchanged endpoints are left untouched. it returns the number of open sockets
+func (s *Service) syncListeners() int {
+	l := &s.listeners
+
+	l.Lock()
+	defer l.Unlock()
+
+	// sockets are only opened while the service is running
+	if l.quit == nil {
+		return 0
+	}
+
+	wanted := make(map[string]bool)
+	for _, endpoint := range s.listenEndpoints() {
+		wanted[endpoint] = true
+	}
+
+	for endpoint, v := range l.open {
+		if wanted[endpoint] {
+			continue
+		}
+
+		delete(l.open, endpoint)
+
+		if err := v.conn.Close(); err != nil {
+			s.logs.Master.LogAlertf("error while closing socket %s [%s]", endpoint, err)
+		}
+	}
+
+	for _, endpoint := range s.listenEndpoints() {
+		if _, ok := l.open[endpoint]; ok {
+			continue
+		}
+
+		conn, err := net.ListenPacket("udp", endpoint)
+		if err != nil {
+			s.logs.Master.LogAlertf("unable to bind to %s - [%s]", endpoint, err)
+			continue
+		}
+
+		v := &listener{
+			endpoint: endpoint,
+			conn:     conn,
+		}
+		l.open[endpoint] = v
+
+		l.wg.Add(1)
+
+		go s.readLoop(v)
+
+		s.logs.Master.Logf("now listening on [%s]", conn.LocalAddr())
+	}
+
+	if len(l.open) == 0 {
+		s.logs.Master.LogAlertf("no listen endpoint could be bound, the master isn't listening on any socket")
+	}
+
+	return len(l.open)
+}
+
+// closeListeners closes every open socket and waits for their read loops to exit
+func (s *Service) closeListeners() {
+	l := &s.listeners
+
+	l.Lock()
+	if l.quit == nil {
+		l.Unlock()
+		return
+	}
+
+	close(l.quit)
+	l.quit = nil
+
+	for endpoint, v := range l.open {
+		if err := v.conn.Close(); err != nil {
+			s.logs.Master.LogAlertf("error while closing socket %s [%s]", endpoint, err)
+		}
+	}
+
+	l.open = nil
+	l.Unlock()
+
+	l.wg.Wait()
+}
+
+// readLoop hands every packet received on a socket to the packet handlers until the socket is closed
+func (s *Service) readLoop(v *listener) {
+	defer s.listeners.wg.Done()
+
+	for {
+		// each packet gets its own buffer, ownership is handed over to the packet handlers
+		buf := s.getBuffer()
+
+		n, addr, err := v.conn.ReadFrom(*buf)
+		if err != nil {
+			s.releaseBuffer(buf)
+
+			if errors.Is(err, net.ErrClosed) {
+				s.logs.Master.Logf("socket %s closed", v.endpoint)
+				return
+			}
+
+			s.logs.Master.LogAlertf("read error on socket %s [%s]", v.endpoint, err)
+
+			// forget the broken socket so the next rehash can bind the endpoint again
+			s.listeners.Lock()
+			if s.listeners.open[v.endpoint] == v {
+				delete(s.listeners.open, v.endpoint)
+				_ = v.conn.Close()
+			}
+			s.listeners.Unlock()
+
+			return
+		}
+
+		s.enqueuePacket(v.conn, addr, buf, n)
+	}
+}
+
+// ListenerStats returns the sockets the master is currently listening on
+func (s *Service) ListenerStats() (out []ListenerStats) {
+	l := &s.listeners
+
+	l.Lock()
+	defer l.Unlock()
+
+	out = make([]ListenerStats, 0, len(l.open))
+	for _, endpoint := range s.listenEndpoints() {
+		if v, ok := l.open[endpoint]; ok {
+			out = append(out, ListenerStats{
+				Endpoint: endpoint,
+				Address:  v.conn.LocalAddr().String(),
+			})
+		}
+	}
+
+	return
+}
@@ -0,0 +1,334 @@
+package master
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/config"
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+)
+
+const (
+	nameActionReject = "reject"
+	nameActionMask   = "mask"
+	nameActionFlag   = "flag"
+)
+
+var (
+	ErrNameRuleEmpty  = errors.New("rule has no pattern or words")
+	ErrNameRuleAction = errors.New("unknown rule action, expected reject, mask or flag")
+	ErrNotFlagged     = errors.New("server is not flagged")
+)
+
+type nameRule struct {
+	name   string
+	action string
+	match  *regexp.Regexp
+}
+
+type namePolicy struct {
+	sync.Mutex
+
+	enabled bool
+	mask    string
+	rules   []*nameRule
+	flagged map[string]*FlaggedServer
+}
+
+type FlaggedServer struct {
+	Address    string
+	Name       string
+	Rules      []string
+	Flagged    time.Time
+	Reviewed   bool
+	ReviewedBy string `json:",omitempty"`
+}
+
+type nameVerdict struct {
+	rejectedBy string
+	masked     string
+	flaggedBy  []string
+}
+
+// compileNameRule builds a single expression from a rule's pattern and word list
+func compileNameRule(rule *config.NameRule) (*regexp.Regexp, error) {
+	switch rule.Action {
+	case nameActionReject, nameActionMask, nameActionFlag:
+	default:
+		return nil, ErrNameRuleAction
+	}
+
+	words := append([]string{}, rule.Words...)
+
+	if rule.WordFile != "" {
+		fileWords, err := readWordFile(rule.WordFile)
+		if err != nil {
+			return nil, err
+		}
+
+		words = append(words, fileWords...)
+	}
+
+	expressions := make([]string, 0)
+	if rule.Pattern != "" {
+		expressions = append(expressions, "(?:"+rule.Pattern+")")
+	}
+
+	quoted := make([]string, 0, len(words))
+	for _, v := range words {
+		if v = strings.TrimSpace(v); v != "" {
+			quoted = append(quoted, regexp.QuoteMeta(v))
+		}
+	}
+
+	if len(quoted) > 0 {
+		expressions = append(expressions, `(?i:\b(?:`+strings.Join(quoted, "|")+`)\b)`)
+	}
+
+	if len(expressions) == 0 {
+		return nil, ErrNameRuleEmpty
+	}
+
+	return regexp.Compile(strings.Join(expressions, "|"))
+}
+
+// readWordFile reads one word per line, blank lines and lines starting with # are skipped
+func readWordFile(fileName string) (out []string, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		out = append(out, line)
+	}
+
+	return out, scanner.Err()
+}
+
+// rehashNamePolicy compiles the configured rules and applies them to every listed server
+func (s *Service) rehashNamePolicy() {
+	cfg := &s.services.Config.Values.Service.NamePolicy
+	p := &s.namePolicy
+
+	rules := make([]*nameRule, 0, len(cfg.Rules))
+
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+
+		name := rule.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+		}
+
+		match, err := compileNameRule(rule)
+		if err != nil {
+			s.logs.NamePolicy.LogAlertf("{%s} unable to compile name rule %s [%s]", service.Rehash, name, err)
+			continue
+		}
+
+		rules = append(rules, &nameRule{
+			name:   name,
+			action: rule.Action,
+			match:  match,
+		})
+	}
+
+	mask := cfg.Mask
+	if mask == "" {
+		mask = "*"
+	}
+
+	p.Lock()
+	p.enabled = cfg.Enabled
+	p.mask = mask
+	p.rules = rules
+
+	if p.flagged == nil {
+		p.flagged = make(map[string]*FlaggedServer)
+	}
+	p.Unlock()
+
+	if cfg.Enabled {
+		s.logs.NamePolicy.Logf("{%s} name policy enabled with %d rules", service.Rehash, len(rules))
+
+		// configs written before the name policy existed list their log components without it
+		if !s.logs.NamePolicy.Enabled() {
+			s.logs.Master.LogAlertf("{%s} the name policy is enabled but the %s log component isn't, rule hits won't be logged",
+				service.Rehash, service.NamePolicyLog)
+		}
+	}
+
+	// rule changes apply to servers that are already listed straight away
+	for ipPort, v := range s.Snapshot().Servers {
+		if v.PingInfoQuery == nil || v.PingInfo == nil || v.Restored || v.Pinned {
+			continue
+		}
+
+		masked, rejected := s.applyNamePolicy(ipPort, string(v.Name))
+		if rejected {
+			s.deleteServer(ipPort)
+			continue
+		}
+
+		if masked != v.MaskedName {
+			s.updateServer(ipPort, false, func(info *ServerInfo) {
+				info.MaskedName = masked
+			})
+		}
+	}
+}
+
+// checkName runs a name through every rule, it expects the name policy lock to be held
+func (p *namePolicy) checkName(name string) (verdict nameVerdict) {
+	verdict.masked = name
+
+	for _, rule := range p.rules {
+		if !rule.match.MatchString(name) {
+			continue
+		}
+
+		switch rule.action {
+		case nameActionReject:
+			if verdict.rejectedBy == "" {
+				verdict.rejectedBy = rule.name
+			}
+
+		case nameActionMask:
+			verdict.masked = rule.match.ReplaceAllStringFunc(verdict.masked, func(match string) string {
+				return strings.Repeat(p.mask, utf8.RuneCountInString(match))
+			})
+
+		case nameActionFlag:
+			verdict.flaggedBy = append(verdict.flaggedBy, rule.name)
+		}
+	}
+
+	return
+}
+
+// applyNamePolicy checks a server name, logging every rule hit and tracking flagged servers.
+// it returns the masked name, which is empty if nothing was masked, and if the server should be rejected
+func (s *Service) applyNamePolicy(ipPort string, name string) (masked string, rejected bool) {
+	p := &s.namePolicy
+
+	p.Lock()
+	if !p.enabled {
+		delete(p.flagged, ipPort)
+		p.Unlock()
+
+		return "", false
+	}
+
+	verdict := p.checkName(name)
+
+	f, ok := p.flagged[ipPort]
+
+	switch {
+	case len(verdict.flaggedBy) == 0:
+		delete(p.flagged, ipPort)
+
+	case !ok || f.Name != name:
+		// a new name needs a new review
+		p.flagged[ipPort] = &FlaggedServer{
+			Address: ipPort,
+			Name:    name,
+			Rules:   verdict.flaggedBy,
+			Flagged: time.Now(),
+		}
+
+	case strings.Join(f.Rules, "\x00") != strings.Join(verdict.flaggedBy, "\x00"):
+		// the rules changed since the name was flagged, the review was against the old rules
+		f.Rules = verdict.flaggedBy
+		f.Reviewed = false
+		f.ReviewedBy = ""
+	}
+	p.Unlock()
+
+	if verdict.rejectedBy != "" {
+		s.logs.NamePolicy.ServerAlertf(ipPort, "name %q matched reject rule %s, rejecting server", name, verdict.rejectedBy)
+		return "", true
+	}
+
+	if verdict.masked != name {
+		s.logs.NamePolicy.ServerAlertf(ipPort, "name %q matched a mask rule, listed as %q", name, verdict.masked)
+		masked = verdict.masked
+	}
+
+	if len(verdict.flaggedBy) > 0 {
+		s.logs.NamePolicy.ServerAlertf(ipPort, "name %q matched flag rules %s, flagged for review", name, strings.Join(verdict.flaggedBy, ", "))
+	}
+
+	return masked, false
+}
+
+// ReviewFlaggedServer marks a flagged server as reviewed, it is flagged again if its name changes
+func (s *Service) ReviewFlaggedServer(ipPort string, reviewedBy string) error {
+	p := &s.namePolicy
+
+	p.Lock()
+	f, ok := p.flagged[ipPort]
+
+	if ok {
+		f.Reviewed = true
+		f.ReviewedBy = reviewedBy
+	}
+	p.Unlock()
+
+	if !ok {
+		return ErrNotFlagged
+	}
+
+	s.logs.NamePolicy.ServerLogf(ipPort, "flagged name %q reviewed by %s", f.Name, reviewedBy)
+
+	return nil
+}
+
+// maintainNamePolicy forgets flags of servers that are no longer listed
+func (s *Service) maintainNamePolicy() {
+	servers := s.Snapshot().Servers
+	p := &s.namePolicy
+
+	p.Lock()
+	defer p.Unlock()
+
+	for k := range p.flagged {
+		if _, ok := servers[k]; !ok {
+			delete(p.flagged, k)
+		}
+	}
+}
+
+// FlaggedServers returns the servers whose names were flagged for review, oldest first
+func (s *Service) FlaggedServers() []FlaggedServer {
+	p := &s.namePolicy
+
+	p.Lock()
+	defer p.Unlock()
+
+	out := make([]FlaggedServer, 0, len(p.flagged))
+	for _, v := range p.flagged {
+		out = append(out, *v)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Flagged.Before(out[j].Flagged)
+	})
+
+	return out
+}
@@ -0,0 +1,119 @@
+package master
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+)
+
+// natRule advertises servers inside a network under a different address to clients outside of the rule's client networks
+type natRule struct {
+	servers     *net.IPNet
+	advertiseAs net.IP
+	clients     []*net.IPNet
+}
+
+// parseNATRules parses the configured rewrite rules, a rule without client networks applies to every client
+// outside of its server network
+func (s *Service) parseNATRules() (out []*natRule) {
+	for _, v := range s.services.Config.Values.Advanced.Network.NATRules {
+		servers, err := parseNetwork(v.Servers)
+		if err != nil {
+			s.logs.Master.LogAlertf("{%s} unable to parse nat rule server network %s", service.Rehash, v.Servers)
+			continue
+		}
+
+		advertiseAs := net.ParseIP(strings.TrimSpace(v.AdvertiseAs))
+		if advertiseAs == nil {
+			s.logs.Master.LogAlertf("{%s} unable to parse nat rule address %s", service.Rehash, v.AdvertiseAs)
+			continue
+		}
+
+		// the darkstar list format can't carry an IPv6 address for an IPv4 server or the other way around
+		if (advertiseAs.To4() != nil) != (servers.IP.To4() != nil) {
+			s.logs.Master.LogAlertf("{%s} nat rule for %s can't advertise servers as %s, the address families differ", service.Rehash, v.Servers, v.AdvertiseAs)
+			continue
+		}
+
+		rule := &natRule{
+			servers:     servers,
+			advertiseAs: advertiseAs,
+		}
+
+		for _, c := range v.Clients {
+			clients, err := parseNetwork(c)
+			if err != nil {
+				s.logs.Master.LogAlertf("{%s} unable to parse nat rule client network %s", service.Rehash, c)
+				continue
+			}
+
+			rule.clients = append(rule.clients, clients)
+		}
+
+		if len(rule.clients) == 0 {
+			rule.clients = []*net.IPNet{servers}
+		}
+
+		out = append(out, rule)
+	}
+
+	return
+}
+
+// insideOf checks if a client is on the same side of the nat as the servers of a rule
+func (r *natRule) insideOf(client net.IP) bool {
+	if client == nil {
+		return false
+	}
+
+	for _, v := range r.clients {
+		if v.Contains(client) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// natView returns a key shared by every client that gets the same addresses from the rewrite rules
+func (snap *Snapshot) natView(client net.IP) string {
+	inside := make([]string, 0)
+
+	for i, v := range snap.natRules {
+		if v.insideOf(client) {
+			inside = append(inside, strconv.Itoa(i))
+		}
+	}
+
+	return strings.Join(inside, ",")
+}
+
+// rewriteNAT returns the address a server is advertised as to a client, matched is false if no rule covers the server
+// and the address is left to the automatic localization. the first rule covering a server decides
+func (snap *Snapshot) rewriteNAT(server net.IP, client net.IP) (ip net.IP, matched bool) {
+	for _, v := range snap.natRules {
+		if !v.servers.Contains(server) {
+			continue
+		}
+
+		if v.insideOf(client) {
+			return server, true
+		}
+
+		return v.advertiseAs, true
+	}
+
+	return server, false
+}
+
+// NATView returns a key shared by every client that gets the same addresses from the rewrite rules
+func (s *Service) NATView(client net.IP) string {
+	return s.Snapshot().natView(client)
+}
+
+// RewriteNAT returns the address a server is advertised as to a client, matched is false if no rule covers the server
+func (s *Service) RewriteNAT(server net.IP, client net.IP) (ip net.IP, matched bool) {
+	return s.Snapshot().rewriteNAT(server, client)
+}
@@ -0,0 +1,75 @@
+package master
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ipv6RateLimitPrefix is the prefix length IPv6 hosts are grouped by, a single host usually controls a whole /64
+const ipv6RateLimitPrefix = 64
+
+// ErrInvalidNetwork is returned for ban, allowlist, quota and nat entries which aren't an ip address or CIDR network
+var ErrInvalidNetwork = errors.New("invalid ip address or CIDR network")
+
+// normalizeUDPAddr unmaps IPv4 addresses received on a dual-stack socket, so the same host always
+// produces the same ip:port identifier
+func normalizeUDPAddr(addr *net.UDPAddr) *net.UDPAddr {
+	ip4 := addr.IP.To4()
+	if ip4 == nil || len(addr.IP) == net.IPv4len {
+		return addr
+	}
+
+	return &net.UDPAddr{
+		IP:   ip4,
+		Port: addr.Port,
+	}
+}
+
+// IsIPv4Server reports whether a server can be represented in the IPv4 only darkstar list format
+func IsIPv4Server(ipPort string) bool {
+	host, _, err := net.SplitHostPort(ipPort)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.To4() != nil
+}
+
+// rateLimitHost returns the key a host is rate limited and temporarily banned by,
+// IPv6 hosts are grouped by their /64 prefix
+func rateLimitHost(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String()
+	}
+
+	mask := net.CIDRMask(ipv6RateLimitPrefix, 8*net.IPv6len) //nolint:gomnd
+	prefix := net.IPNet{
+		IP:   ip.Mask(mask),
+		Mask: mask,
+	}
+
+	return prefix.String()
+}
+
+// parseNetwork accepts either a CIDR network or a single ip address
+func parseNetwork(input string) (*net.IPNet, error) {
+	input = strings.TrimSpace(input)
+
+	if _, network, err := net.ParseCIDR(input); err == nil {
+		return network, nil
+	}
+
+	ip := net.ParseIP(input)
+	if ip == nil {
+		return nil, ErrInvalidNetwork
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}, nil //nolint:gomnd
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}, nil //nolint:gomnd
+}
@@ -0,0 +1,316 @@
+package master
+
+import (
+	"math"
+	"net"
+	"sync"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/protocol"
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/server"
+)
+
+// listEntrySize is the size of a single server entry: <0x06><4 bytes ipv4 address><2 bytes port>
+const listEntrySize = 7
+
+const (
+	remotePublic = iota
+	remotePrivate
+	remoteLoopback
+)
+
+type listView struct {
+	laddr  string
+	remote int
+	nat    string
+}
+
+type listCache struct {
+	sync.Mutex
+
+	generation uint32
+	views      map[listView][]byte
+
+	counters struct {
+		builds uint64
+		hits   uint64
+	}
+}
+
+type ListCacheStats struct {
+	Generation uint32
+	Views      int
+	Builds     uint64
+	Hits       uint64
+}
+
+// localViewAddress finds the local interface address a remote address should see servers through
+func (s *Service) localViewAddress(raddr net.Addr) net.Addr {
+	udpAddr, ok := raddr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+
+	// list entries are IPv4 only, so only an IPv4 address can stand in for a server
+	for _, v := range s.services.STUN.LocalAddresses() {
+		if ip4 := v.IP.To4(); ip4 != nil && v.Contains(udpAddr.IP) {
+			return &net.UDPAddr{
+				IP: ip4,
+			}
+		}
+	}
+
+	return nil
+}
+
+// remoteIP returns the ip address of a remote udp address, or nil for any other kind of address
+func remoteIP(raddr net.Addr) net.IP {
+	if udpAddr, ok := raddr.(*net.UDPAddr); ok {
+		return udpAddr.IP
+	}
+
+	return nil
+}
+
+func newListView(laddr net.Addr, raddr net.Addr) (out listView) {
+	if laddr != nil {
+		out.laddr = laddr.(*net.UDPAddr).IP.String()
+	}
+
+	udpAddr, ok := raddr.(*net.UDPAddr)
+
+	switch {
+	case !ok:
+		out.remote = remotePublic
+	case udpAddr.IP.IsLoopback():
+		out.remote = remoteLoopback
+	case udpAddr.IP.IsPrivate():
+		out.remote = remotePrivate
+	}
+
+	return
+}
+
+// listEntries returns the encoded server entries for the view of the given local and remote address,
+// entries are only re-encoded when the advertised servers have changed since they were last built
+func (s *Service) listEntries(snap *Snapshot, laddr net.Addr, raddr net.Addr) []byte {
+	c := &s.listCache
+	client := remoteIP(raddr)
+
+	view := newListView(laddr, raddr)
+	view.nat = snap.natView(client)
+
+	c.Lock()
+	defer c.Unlock()
+
+	// the generation is bumped whenever the advertised servers or the options used to localize them change
+	if c.views == nil || c.generation != snap.generation {
+		c.views = make(map[listView][]byte)
+		c.generation = snap.generation
+	}
+
+	if entries, ok := c.views[view]; ok {
+		c.counters.hits++
+		return entries
+	}
+
+	pinned, advertised := s.listedServers(snap, client)
+
+	// the first byte of a set is a server count which doesn't fit more than 255 entries, the packets get their own.
+	// pinned servers are encoded separately so they always come first
+	entries := s.masters.Main.MarshalBinarySet(&snap.options, pinned, laddr, raddr)[1:]
+	entries = append(entries, s.masters.Main.MarshalBinarySet(&snap.options, advertised, laddr, raddr)[1:]...)
+	c.views[view] = entries
+
+	c.counters.builds++
+
+	return entries
+}
+
+// listedServers picks the servers listed to a client, keyed by the address they are listed as. pinned servers are
+// returned on their own so they can be encoded first
+func (s *Service) listedServers(snap *Snapshot, client net.IP) (pinned map[string]*server.Server, advertised map[string]*server.Server) {
+	// the darkstar list format has no room for IPv6 servers, they are only listed by the HTTPD
+	pinned = make(map[string]*server.Server)
+	advertised = make(map[string]*server.Server, len(snap.Advertised))
+
+	// the server behind each listed address, servers sharing a port behind the same nat rule can't all be listed
+	sources := make(map[string]string, len(snap.Advertised))
+
+	for k, v := range snap.Advertised {
+		if !IsIPv4Server(k) {
+			continue
+		}
+
+		// servers only known through other masters can be left to those masters
+		if info, ok := snap.Servers[k]; ok && info.isPolledOnly() && !snap.advertisePolled {
+			continue
+		}
+
+		// servers covered by a nat rule are advertised under the rule's address instead
+		address := k
+		host, port, _ := net.SplitHostPort(k)
+
+		if ip, matched := snap.rewriteNAT(net.ParseIP(host), client); matched {
+			address = net.JoinHostPort(ip.String(), port)
+		}
+
+		// the lowest address keeps the entry, so the same server is listed on every build
+		if other, ok := sources[address]; ok {
+			if k > other {
+				s.logs.Master.ServerAlertf(k, "not listed, advertised as %s which is already used by %s", address, other)
+				continue
+			}
+
+			s.logs.Master.ServerAlertf(other, "not listed, advertised as %s which is already used by %s", address, k)
+			delete(pinned, address)
+			delete(advertised, address)
+		}
+
+		sources[address] = k
+
+		if info, ok := snap.Servers[k]; ok && info.Pinned {
+			pinned[address] = v
+		} else {
+			advertised[address] = v
+		}
+	}
+
+	return pinned, advertised
+}
+
+// PublishedServers returns the addresses a client outside our networks is sent in the server list, after the nat
+// rules and the external address are applied. other masters poll us from outside, so their lists carry our servers
+// in this form
+func (s *Service) PublishedServers() map[string]bool {
+	snap := s.Snapshot()
+	pinned, advertised := s.listedServers(snap, nil)
+	out := make(map[string]bool, len(pinned)+len(advertised))
+
+	for _, set := range []map[string]*server.Server{pinned, advertised} {
+		for k := range set {
+			host, port, _ := net.SplitHostPort(k)
+			ip := net.ParseIP(host)
+
+			for _, v := range snap.options.LocalNetworks {
+				if snap.options.ExternalIP != nil && v.Contains(ip) {
+					ip = snap.options.ExternalIP
+				}
+			}
+
+			out[net.JoinHostPort(ip.String(), port)] = true
+		}
+	}
+
+	return out
+}
+
+// listHeader returns the list header carrying the master's name, id and MOTD
+func listHeader(snap *Snapshot, motd string, motdJunk string) protocol.Master {
+	return protocol.Master{
+		CommonName: snap.commonName,
+		MasterID:   snap.masterID,
+		MOTD:       motd,
+		MOTDJunk:   motdJunk,
+	}
+}
+
+// listPacketCapacity returns how many entries fit in the first packet of a list, after the header, and in
+// each packet after it. sizes are worked out as ints, a long MOTD can leave no room in the first packet
+func listPacketCapacity(maxPacketSize uint16, headerSize int) (first int, overflow int) {
+	// every packet carries the packet header, a count byte and a length trailer
+	overhead := protocol.HeaderSize + 2 //nolint:gomnd
+
+	first = (int(maxPacketSize) - overhead - headerSize) / listEntrySize
+	overflow = (int(maxPacketSize) - overhead) / listEntrySize
+
+	// the count is a single byte, and each packet after the first has to make progress
+	if first < 0 {
+		first = 0
+	}
+
+	if first > math.MaxUint8 {
+		first = math.MaxUint8
+	}
+
+	if overflow < 1 {
+		overflow = 1
+	}
+
+	if overflow > math.MaxUint8 {
+		overflow = math.MaxUint8
+	}
+
+	return first, overflow
+}
+
+// firstPacketEntries returns the entries that fit in a single packet along with the list header
+func firstPacketEntries(snap *Snapshot, motd string, motdJunk string, entries []byte) []byte {
+	m := listHeader(snap, motd, motdJunk)
+	first, _ := listPacketCapacity(snap.options.MaxServerPacketSize, len(m.MarshalBinaryHeader()))
+
+	if len(entries) > first*listEntrySize {
+		return entries[:first*listEntrySize]
+	}
+
+	return entries
+}
+
+// generateList assembles a complete server list response from pre-encoded entries. packets are laid out the
+// same way protocol.Master.GeneratePackets lays them out, which can't be used as it encodes every server again
+// and sorts them by address, losing the cached entries and the pinned servers at the top of the list
+func (s *Service) generateList(snap *Snapshot, motd string, motdJunk string, entries []byte, key uint16) (output [][]byte) {
+	m := listHeader(snap, motd, motdJunk)
+	header := m.MarshalBinaryHeader()
+	first, overflow := listPacketCapacity(snap.options.MaxServerPacketSize, len(header))
+
+	// the first packet carries the header and as many entries as fit, the rest are spread over as few packets as possible
+	chunks := make([][]byte, 0, 1)
+
+	for n := first; ; n = overflow {
+		if n > len(entries)/listEntrySize {
+			n = len(entries) / listEntrySize
+		}
+
+		chunks = append(chunks, entries[:n*listEntrySize])
+		entries = entries[n*listEntrySize:]
+
+		if len(entries) < listEntrySize {
+			break
+		}
+	}
+
+	pkt := protocol.NewPacket()
+	pkt.Type = protocol.MasterServerList
+	pkt.ID = m.MasterID
+	pkt.Key = key
+	pkt.Total = byte(len(chunks))
+
+	for i, v := range chunks {
+		pkt.Number = byte(i + 1)
+		pkt.Data = append([]byte{byte(len(v) / listEntrySize)}, v...)
+
+		if i == 0 {
+			pkt.Data = append(append([]byte{}, header...), pkt.Data...)
+		}
+
+		binOut, _ := pkt.MarshalBinary()
+		output = append(output, binOut)
+	}
+
+	return output
+}
+
+// ListCacheStats returns the state of the pre-encoded server list cache
+func (s *Service) ListCacheStats() ListCacheStats {
+	c := &s.listCache
+
+	c.Lock()
+	defer c.Unlock()
+
+	return ListCacheStats{
+		Generation: c.generation,
+		Views:      len(c.views),
+		Builds:     c.counters.builds,
+		Hits:       c.counters.hits,
+	}
+}
@@ -0,0 +1,187 @@
+package master
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service/file"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/query"
+)
+
+type registryFile struct {
+	Saved   time.Time
+	Servers []registryEntry
+}
+
+type registryEntry struct {
+	Address       string
+	LastSeen      time.Time
+	SolicitedTime time.Time
+	Stale         bool      `json:",omitempty"`
+	FailedProbes  int       `json:",omitempty"`
+	Flaps         int       `json:",omitempty"`
+	LastFlap      time.Time `json:",omitempty"`
+	Origin        string    `json:",omitempty"`
+	OriginMaster  string    `json:",omitempty"`
+}
+
+// SaveRegistry writes the list of known servers to the configured registry file, returning the number of
+// servers written. the list is read from a single snapshot so the file and the count always agree.
+// saves from the sweep and from shutdown can overlap, so they are serialized
+func (s *Service) SaveRegistry() (saved int, err error) {
+	fileName := s.services.Config.Values.Service.Registry.File
+	if fileName == "" {
+		return 0, nil
+	}
+
+	s.registrySave.Lock()
+	defer s.registrySave.Unlock()
+
+	out := registryFile{
+		Saved:   time.Now(),
+		Servers: make([]registryEntry, 0),
+	}
+
+	for k, v := range s.currentSnapshot().Servers {
+		out.Servers = append(out.Servers, registryEntry{
+			Address:       k,
+			LastSeen:      v.LastSeen,
+			SolicitedTime: v.SolicitedTime,
+			Stale:         v.Stale,
+			FailedProbes:  v.FailedProbes,
+			Flaps:         v.Flaps,
+			LastFlap:      v.LastFlap,
+			Origin:        v.Origin,
+			OriginMaster:  v.OriginMaster,
+		})
+	}
+
+	data, err := json.MarshalIndent(out, "", "    ")
+	if err != nil {
+		return 0, err
+	}
+
+	return len(out.Servers), file.WriteAtomic(fileName, data, file.UserReadWrite|file.GroupRead|file.OtherRead)
+}
+
+// loadRegistry reads the registry file and adds each entry to the registry as an unverified server,
+// returning the ip:port of every restored entry
+func (s *Service) loadRegistry() (restored []string, err error) {
+	restored = make([]string, 0)
+
+	fileName := s.services.Config.Values.Service.Registry.File
+	if fileName == "" {
+		return
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+
+		return
+	}
+
+	in := registryFile{}
+
+	err = json.Unmarshal(data, &in)
+	if err != nil {
+		return
+	}
+
+	known := s.Snapshot().Servers
+
+	for _, v := range in.Servers {
+		if _, ok := known[v.Address]; ok {
+			continue
+		}
+
+		if !s.isAllowlisted(v.Address) {
+			s.logs.Registration.ServerAlertf(v.Address, "not restoring registry entry, server is not on the allowlist")
+			continue
+		}
+
+		addr, err := net.ResolveUDPAddr("udp", v.Address)
+		if err != nil {
+			s.logs.Master.ServerAlertf(v.Address, "unable to parse registry entry [%s]", err)
+			continue
+		}
+
+		entry := v
+		s.updateServer(v.Address, true, func(info *ServerInfo) {
+			info.PingInfoQuery = query.NewPingInfoQueryWithOptions(entry.Address, s.Options)
+			info.Server.Address = addr
+			info.Server.LastSeen = entry.LastSeen
+			info.SolicitedTime = entry.SolicitedTime
+			info.Restored = true
+
+			// restored servers are re-verified from scratch, only the flap history carries over
+			info.Flaps = entry.Flaps
+			info.LastFlap = entry.LastFlap
+			info.Origin = entry.Origin
+			info.OriginMaster = entry.OriginMaster
+		})
+
+		restored = append(restored, v.Address)
+	}
+
+	s.logs.Master.Logf("restored %d servers from %s saved on %s", len(restored), fileName, in.Saved.Format(time.Stamp))
+
+	return
+}
+
+// verifyRestoredServers re-queries servers loaded from the registry file and only
+// lists the ones that still respond, using as many workers as the stale server sweep
+func (s *Service) verifyRestoredServers(restored []string) {
+	workers := s.services.Config.Values.Advanced.Maintenance.SweepWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	jobs := make(chan string)
+	verified := 0
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ipPort := range jobs {
+				removed, _ := s.CheckRemoveServer(ipPort)
+				if removed {
+					continue
+				}
+
+				svr, ok := s.Snapshot().Servers[ipPort]
+				if !ok {
+					continue
+				}
+
+				s.registerPingInfo(&svr.Server.Address, ipPort)
+
+				mu.Lock()
+				verified++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, ipPort := range restored {
+		jobs <- ipPort
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	s.logs.Master.Logf("{%s} verified %d of %d restored servers", service.Startup, verified, len(restored))
+}
@@ -0,0 +1,109 @@
+package master
+
+import (
+	"net"
+	"time"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/query"
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/server"
+)
+
+// rehashPinned lists every configured pinned server, servers that are no longer pinned have to register again
+// like any other server. pinned servers are always advertised, they are refreshed by the sweep but never removed
+func (s *Service) rehashPinned() {
+	pinned := make(map[string]string)
+
+	for _, v := range s.services.Config.Values.Service.Pinned {
+		ipPort, err := normalizeServerAddress(v.Address)
+		if err != nil {
+			s.logs.Registration.LogAlertf("{%s} unable to parse pinned server %s [%s]", service.Rehash, v.Address, err)
+			continue
+		}
+
+		pinned[ipPort] = v.Name
+	}
+
+	added := make([]string, 0)
+
+	s.Lock()
+	for ipPort, info := range s.serverList {
+		if _, ok := pinned[ipPort]; ok || !info.Pinned {
+			continue
+		}
+
+		if _, ok := s.masters.Main.Servers[ipPort]; ok {
+			host, _, _ := net.SplitHostPort(ipPort)
+			s.removeQuota(host)
+
+			delete(s.masters.Main.Servers, ipPort)
+		}
+
+		delete(s.serverList, ipPort)
+		s.generation++
+
+		s.logs.Registration.ServerLogf(ipPort, "{%s} no longer pinned, removed until it sends a heartbeat", service.Rehash)
+	}
+
+	for ipPort, name := range pinned {
+		addr, err := net.ResolveUDPAddr("udp", ipPort)
+		if err != nil {
+			s.logs.Registration.ServerAlertf(ipPort, "{%s} unable to resolve pinned server [%s]", service.Rehash, err)
+			continue
+		}
+
+		info, ok := s.serverList[ipPort]
+		if ok {
+			info = info.clone()
+		} else {
+			// the configured name stands in until the server answers its first query
+			info = &ServerInfo{
+				PingInfoQuery: query.NewPingInfoQueryWithOptions(ipPort, s.Options),
+				Server: &server.Server{
+					Address: addr,
+				},
+			}
+			info.PingInfo.Name = []byte(name)
+
+			added = append(added, ipPort)
+		}
+
+		info.Pinned = true
+		info.Restored = false
+		info.Origin, info.OriginMaster = OriginPinned, ""
+		s.serverList[ipPort] = info
+
+		if _, ok := s.masters.Main.Servers[ipPort]; !ok {
+			// pinned servers don't have to fit in a quota, but they still count towards it
+			host, _, _ := net.SplitHostPort(ipPort)
+			usage := s.quotaUsage(host)
+			s.addQuota(&usage)
+
+			s.masters.Main.Servers[ipPort] = &server.Server{
+				Address:  addr,
+				LastSeen: time.Now(),
+			}
+			s.generation++
+		}
+	}
+
+	s.publish()
+	s.Unlock()
+
+	if len(pinned) > 0 {
+		s.logs.Registration.Logf("{%s} %d pinned servers", service.Rehash, len(pinned))
+	}
+
+	// fetch live info for new pinned servers straight away rather than waiting for the sweep
+	for _, ipPort := range added {
+		go s.CheckRemoveServer(ipPort)
+	}
+}
+
+// isPinned checks if a server is a configured pinned server
+func (s *Service) isPinned(ipPort string) bool {
+	info, ok := s.Snapshot().Servers[ipPort]
+
+	return ok && info.Pinned
+}
@@ -0,0 +1,51 @@
+package master
+
+import (
+	"time"
+)
+
+// maxProbeBackoffShift caps the exponent of the retry backoff so the duration can't overflow
+const maxProbeBackoffShift = 16
+
+// probeBackoff returns how long to wait before re-querying a stale server, doubling the retry
+// interval for each failed probe and each flap that is still remembered
+func (s *Service) probeBackoff(svr *ServerInfo, failures int, now time.Time) time.Duration {
+	cfg := &s.services.Config.Values.Service.Probe
+
+	shift := failures - 1
+	if now.Sub(svr.LastFlap) <= cfg.FlapMemory.Duration {
+		shift += svr.Flaps
+	}
+
+	if shift > maxProbeBackoffShift {
+		shift = maxProbeBackoffShift
+	}
+
+	backoff := cfg.RetryInterval.Duration * time.Duration(1<<shift)
+	if cfg.MaxBackoff.Duration > 0 && backoff > cfg.MaxBackoff.Duration {
+		backoff = cfg.MaxBackoff.Duration
+	}
+
+	return backoff
+}
+
+// recoverServer clears the stale state of a server that answered again, a stale server coming back counts as a flap.
+// it is called on an entry copy while the registry lock is held
+func (s *Service) recoverServer(ipPort string, info *ServerInfo, now time.Time) {
+	if !info.Stale {
+		return
+	}
+
+	if now.Sub(info.LastFlap) > s.services.Config.Values.Service.Probe.FlapMemory.Duration {
+		info.Flaps = 0
+	}
+
+	info.Flaps++
+	info.LastFlap = now
+
+	s.logs.Master.ServerLogf(ipPort, "recovered after %d failed probes, flaps: %d", info.FailedProbes, info.Flaps)
+
+	info.FailedProbes = 0
+	info.Stale = false
+	info.NextProbe = time.Time{}
+}
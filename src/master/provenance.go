@@ -0,0 +1,41 @@
+package master
+
+// the origins a listed server can have, a polled server becomes a heartbeat server once it sends us a heartbeat
+const (
+	OriginHeartbeat = "heartbeat"
+	OriginPoll      = "poll"
+	OriginPinned    = "pinned"
+)
+
+// updateOrigin records where a verified server came from. direct heartbeats replace a poll origin, while a poll
+// never replaces a heartbeat origin, and pinned servers stay pinned. it expects the lock to be held
+func (s *Service) updateOrigin(ipPort string, info *ServerInfo, origin string, originMaster string) {
+	previous := info.Origin
+
+	// the udp list may leave out polled servers, so it has to be rebuilt when that changes
+	defer func() {
+		if info.Origin != previous {
+			s.generation++
+		}
+	}()
+
+	switch {
+	case info.Pinned:
+		info.Origin, info.OriginMaster = OriginPinned, ""
+
+	case origin == OriginHeartbeat:
+		if info.Origin == OriginPoll {
+			s.logs.Registration.ServerLogf(ipPort, "now sends heartbeats directly, previously polled from %s", info.OriginMaster)
+		}
+
+		info.Origin, info.OriginMaster = OriginHeartbeat, ""
+
+	case info.Origin == "":
+		info.Origin, info.OriginMaster = origin, originMaster
+	}
+}
+
+// isPolledOnly checks if a server is only known through another master
+func (i *ServerInfo) isPolledOnly() bool {
+	return i.Origin == OriginPoll && !i.Pinned
+}
@@ -0,0 +1,260 @@
+package master
+
+import (
+	"net"
+	"sort"
+	"strconv"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+)
+
+const (
+	defaultIPv4SubnetPrefix = 24
+	defaultIPv6SubnetPrefix = 64
+)
+
+type quotaRule struct {
+	network          *net.IPNet
+	serversPerIP     uint16
+	serversPerSubnet uint16
+}
+
+// quotas tracks advertised servers per ip address and per subnet, it is guarded by the registry lock
+type quotas struct {
+	rules     []*quotaRule
+	perIP     map[string]uint16
+	perSubnet map[string]uint16
+}
+
+// QuotaUsage is the number of advertised servers for an ip address and its subnet along with their limits,
+// a subnet limit of 0 is unlimited
+type QuotaUsage struct {
+	IP          string
+	IPCount     uint16
+	IPLimit     uint16
+	Subnet      string
+	SubnetCount uint16
+	SubnetLimit uint16
+	Rule        string
+}
+
+type QuotaStats struct {
+	Rules   int
+	IPs     int
+	Subnets int
+}
+
+// exceededBy reports which limit is exceeded if the counts were raised by extra servers
+func (u *QuotaUsage) exceededBy(extra uint16) string {
+	if u.IPCount+extra > u.IPLimit {
+		return "IP"
+	}
+
+	if u.SubnetLimit > 0 && u.SubnetCount+extra > u.SubnetLimit {
+		return "subnet"
+	}
+
+	return ""
+}
+
+// quotaLimit formats a limit for logging, 0 is unlimited
+func quotaLimit(limit uint16) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+
+	return strconv.Itoa(int(limit))
+}
+
+// rehashQuotas parses the configured quota rules and recounts the advertised servers, it expects the registry lock to be held
+func (s *Service) rehashQuotas() {
+	cfg := &s.services.Config.Values.Service.Quotas
+	rules := make([]*quotaRule, 0, len(cfg.Rules))
+
+	for _, v := range cfg.Rules {
+		network, err := parseNetwork(v.Network)
+		if err != nil {
+			s.logs.Registration.LogAlertf("{%s} unable to parse quota rule network %s", service.Rehash, v.Network)
+			continue
+		}
+
+		rules = append(rules, &quotaRule{
+			network:          network,
+			serversPerIP:     v.ServersPerIP,
+			serversPerSubnet: v.ServersPerSubnet,
+		})
+	}
+
+	// the most specific network wins when rules overlap
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, _ := rules[i].network.Mask.Size()
+		b, _ := rules[j].network.Mask.Size()
+
+		return a > b
+	})
+
+	s.quotas.rules = rules
+
+	// the subnet prefixes may have changed, so the counts are rebuilt from the advertised servers
+	s.quotas.perIP = make(map[string]uint16)
+	s.quotas.perSubnet = make(map[string]uint16)
+
+	for ipPort := range s.masters.Main.Servers {
+		host, _, _ := net.SplitHostPort(ipPort)
+		usage := s.quotaUsage(host)
+		s.addQuota(&usage)
+	}
+}
+
+// quotaSubnet returns the subnet an ip address is counted against
+func (s *Service) quotaSubnet(ip net.IP) string {
+	cfg := &s.services.Config.Values.Service.Quotas
+
+	prefix, bits := cfg.IPv4SubnetPrefix, 8*net.IPv4len //nolint:gomnd
+	if prefix <= 0 || prefix > bits {
+		prefix = defaultIPv4SubnetPrefix
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else {
+		prefix, bits = cfg.IPv6SubnetPrefix, 8*net.IPv6len //nolint:gomnd
+		if prefix <= 0 || prefix > bits {
+			prefix = defaultIPv6SubnetPrefix
+		}
+	}
+
+	mask := net.CIDRMask(prefix, bits)
+	subnet := net.IPNet{
+		IP:   ip.Mask(mask),
+		Mask: mask,
+	}
+
+	return subnet.String()
+}
+
+// quotaUsage looks up the counts and limits for a host, it expects the registry lock to be held
+func (s *Service) quotaUsage(host string) QuotaUsage {
+	cfg := &s.services.Config.Values.Service
+
+	usage := QuotaUsage{
+		IP:          host,
+		IPCount:     s.quotas.perIP[host],
+		IPLimit:     cfg.ServersPerIP,
+		Subnet:      host,
+		SubnetLimit: cfg.Quotas.ServersPerSubnet,
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return usage
+	}
+
+	usage.Subnet = s.quotaSubnet(ip)
+	usage.SubnetCount = s.quotas.perSubnet[usage.Subnet]
+
+	for _, v := range s.quotas.rules {
+		if !v.network.Contains(ip) {
+			continue
+		}
+
+		usage.Rule = v.network.String()
+
+		if v.serversPerIP > 0 {
+			usage.IPLimit = v.serversPerIP
+		}
+
+		if v.serversPerSubnet > 0 {
+			usage.SubnetLimit = v.serversPerSubnet
+		}
+
+		break
+	}
+
+	return usage
+}
+
+// addQuota counts a newly advertised server, it expects the registry lock to be held
+func (s *Service) addQuota(usage *QuotaUsage) {
+	usage.IPCount++
+	usage.SubnetCount++
+
+	s.quotas.perIP[usage.IP] = usage.IPCount
+	s.quotas.perSubnet[usage.Subnet] = usage.SubnetCount
+}
+
+// removeQuota releases the slot of a server that is no longer advertised, it expects the registry lock to be held
+func (s *Service) removeQuota(host string) (remaining uint16) {
+	usage := s.quotaUsage(host)
+
+	if usage.IPCount <= 1 {
+		delete(s.quotas.perIP, host)
+	} else {
+		remaining = usage.IPCount - 1
+		s.quotas.perIP[host] = remaining
+	}
+
+	if usage.SubnetCount <= 1 {
+		delete(s.quotas.perSubnet, usage.Subnet)
+	} else {
+		s.quotas.perSubnet[usage.Subnet] = usage.SubnetCount - 1
+	}
+
+	return
+}
+
+// overQuota checks if an advertised server's ip address or subnet is above its limit,
+// which happens when limits are lowered on rehash
+func (s *Service) overQuota(ipPort string) (usage QuotaUsage, exceeded string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.masters.Main.Servers[ipPort]; !ok {
+		return
+	}
+
+	host, _, _ := net.SplitHostPort(ipPort)
+	usage = s.quotaUsage(host)
+
+	return usage, usage.exceededBy(0)
+}
+
+// enforceQuotas removes servers above a lowered limit after a rehash, servers are removed in address order
+// until their ip address and subnet are back within their limits. pinned servers don't count against quotas
+func (s *Service) enforceQuotas() {
+	snap := s.Snapshot()
+
+	keys := make([]string, 0, len(snap.Advertised))
+	for k := range snap.Advertised {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, ipPort := range keys {
+		if info, ok := snap.Servers[ipPort]; ok && info.Pinned {
+			continue
+		}
+
+		usage, exceeded := s.overQuota(ipPort)
+		if exceeded == "" {
+			continue
+		}
+
+		remaining := s.deleteServer(ipPort)
+		s.logs.Registration.ServerAlertf(ipPort, "{%s} removing server over the per %s quota - IP: %d/%d, subnet %s: %d/%s, new count for ip: %d",
+			service.Rehash, exceeded, usage.IPCount, usage.IPLimit, usage.Subnet, usage.SubnetCount, quotaLimit(usage.SubnetLimit), remaining)
+	}
+}
+
+// QuotaStats returns the number of quota rules and tracked ip addresses and subnets
+func (s *Service) QuotaStats() QuotaStats {
+	s.Lock()
+	defer s.Unlock()
+
+	return QuotaStats{
+		Rules:   len(s.quotas.rules),
+		IPs:     len(s.quotas.perIP),
+		Subnets: len(s.quotas.perSubnet),
+	}
+}
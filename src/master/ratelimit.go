@@ -0,0 +1,234 @@
+package master
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/protocol"
+)
+
+const (
+	// violationWindow is how long rate limit violations count towards a temporary ban
+	violationWindow = time.Minute
+
+	// offenderMemory is how long repeat offenses are remembered for ban escalation
+	offenderMemory = 24 * time.Hour
+
+	// maxBanEscalation caps how many times a temporary ban duration is doubled
+	maxBanEscalation = 6
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket at perMinute tokens a minute up to burst, then tries to remove a single token
+func (b *tokenBucket) take(perMinute int, burst int, now time.Time) bool {
+	b.tokens += now.Sub(b.last).Minutes() * float64(perMinute)
+	b.last = now
+
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+type rateLimitKey struct {
+	host string
+	kind protocol.PacketType
+}
+
+type offender struct {
+	violations    int
+	lastViolation time.Time
+	bans          int
+	bannedUntil   time.Time
+}
+
+type rateLimiter struct {
+	sync.Mutex
+
+	buckets   map[rateLimitKey]*tokenBucket
+	offenders map[string]*offender
+
+	counters struct {
+		limited uint64
+		bans    uint64
+	}
+}
+
+type TemporaryBan struct {
+	Host  string
+	Until time.Time
+}
+
+type RateLimitStats struct {
+	Enabled    bool
+	Tracked    int
+	Limited    uint64
+	Bans       uint64
+	ActiveBans []TemporaryBan
+}
+
+// allowPacket applies the token bucket for this source and packet type. queries are limited per host and hosts
+// that keep going over the limit are temporarily banned. heartbeats are limited per server, a host may run many
+// of them, and as their source is easily spoofed they never lead to a ban
+func (s *Service) allowPacket(addr *net.UDPAddr, kind protocol.PacketType) bool {
+	cfg := &s.services.Config.Values.Service.RateLimit
+	if !cfg.Enabled {
+		return true
+	}
+
+	host := rateLimitHost(addr.IP)
+	key := rateLimitKey{host: host, kind: protocol.PingInfoQuery}
+	perMinute, burst := cfg.QueriesPerMinute, cfg.QueryBurst
+
+	if kind == protocol.MasterServerHeartbeat {
+		key = rateLimitKey{host: addr.String(), kind: kind}
+		perMinute, burst = cfg.HeartbeatsPerMinute, cfg.HeartbeatBurst
+	}
+
+	now := time.Now()
+	r := &s.rateLimiter
+
+	r.Lock()
+	defer r.Unlock()
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &tokenBucket{
+			tokens: float64(burst),
+			last:   now,
+		}
+		r.buckets[key] = b
+	}
+
+	if b.take(perMinute, burst, now) {
+		return true
+	}
+
+	r.counters.limited++
+
+	if kind == protocol.MasterServerHeartbeat {
+		return false
+	}
+
+	o, ok := r.offenders[host]
+	if !ok {
+		o = new(offender)
+		r.offenders[host] = o
+	}
+
+	if now.Sub(o.lastViolation) > violationWindow {
+		o.violations = 0
+	}
+
+	o.violations++
+	o.lastViolation = now
+
+	if cfg.BanThreshold > 0 && o.violations >= cfg.BanThreshold && now.After(o.bannedUntil) {
+		escalation := o.bans
+		if escalation > maxBanEscalation {
+			escalation = maxBanEscalation
+		}
+
+		duration := cfg.BanDuration.Duration * time.Duration(1<<escalation)
+		o.bannedUntil = now.Add(duration)
+		o.bans++
+		o.violations = 0
+
+		r.counters.bans++
+		s.logs.Banned.ServerAlertf(host, "temporarily banned for %s after exceeding the query rate limit (offense #%d)", duration, o.bans)
+	}
+
+	return false
+}
+
+// isTemporarilyBanned checks if a host is currently serving a rate limit ban
+func (s *Service) isTemporarilyBanned(host string) bool {
+	r := &s.rateLimiter
+
+	r.Lock()
+	defer r.Unlock()
+
+	o, ok := r.offenders[host]
+
+	return ok && time.Now().Before(o.bannedUntil)
+}
+
+// maintainRateLimiter drops idle buckets and forgets offenders whose bans have expired
+func (s *Service) maintainRateLimiter() {
+	cfg := &s.services.Config.Values.Service.RateLimit
+	now := time.Now()
+	r := &s.rateLimiter
+
+	r.Lock()
+	defer r.Unlock()
+
+	for k, b := range r.buckets {
+		// a bucket that would be full again carries no state worth keeping
+		perMinute, burst := cfg.QueriesPerMinute, cfg.QueryBurst
+		if k.kind == protocol.MasterServerHeartbeat {
+			perMinute, burst = cfg.HeartbeatsPerMinute, cfg.HeartbeatBurst
+		}
+
+		if b.tokens+now.Sub(b.last).Minutes()*float64(perMinute) >= float64(burst) {
+			delete(r.buckets, k)
+		}
+	}
+
+	for host, o := range r.offenders {
+		if !o.bannedUntil.IsZero() && now.After(o.bannedUntil) {
+			s.logs.Banned.ServerLogf(host, "{%s} temporary ban expired", service.Maintenance)
+			o.bannedUntil = time.Time{}
+		}
+
+		if now.Sub(o.lastViolation) > offenderMemory && now.After(o.bannedUntil) {
+			delete(r.offenders, host)
+		}
+	}
+}
+
+// RateLimitStats returns the current state of the packet rate limiter
+func (s *Service) RateLimitStats() RateLimitStats {
+	r := &s.rateLimiter
+	now := time.Now()
+
+	r.Lock()
+	defer r.Unlock()
+
+	out := RateLimitStats{
+		Enabled:    s.services.Config.Values.Service.RateLimit.Enabled,
+		Tracked:    len(r.buckets),
+		Limited:    r.counters.limited,
+		Bans:       r.counters.bans,
+		ActiveBans: make([]TemporaryBan, 0),
+	}
+
+	for host, o := range r.offenders {
+		if now.Before(o.bannedUntil) {
+			out.ActiveBans = append(out.ActiveBans, TemporaryBan{
+				Host:  host,
+				Until: o.bannedUntil,
+			})
+		}
+	}
+
+	sort.Slice(out.ActiveBans, func(i, j int) bool {
+		return out.ActiveBans[i].Until.Before(out.ActiveBans[j].Until)
+	})
+
+	return out
+}
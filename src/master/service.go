@@ -1,11 +1,10 @@
 package master
 
 import (
-	"bytes"
 	"errors"
-	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/StarsiegePlayers/neos-thicc-master/src/config"
@@ -14,7 +13,6 @@ import (
 	"github.com/StarsiegePlayers/neos-thicc-master/src/stats"
 	"github.com/StarsiegePlayers/neos-thicc-master/src/stun"
 
-	"github.com/StarsiegePlayers/darkstar-query-go/v2"
 	"github.com/StarsiegePlayers/darkstar-query-go/v2/protocol"
 	"github.com/StarsiegePlayers/darkstar-query-go/v2/query"
 	"github.com/StarsiegePlayers/darkstar-query-go/v2/server"
@@ -25,12 +23,33 @@ const dummythicc = "dummythicc"
 type Service struct {
 	sync.Mutex
 
-	Options        *protocol.Options
-	IPServiceCount map[string]uint16
-	ServerList     map[string]*ServerInfo
+	Options *protocol.Options
 
-	pconn  net.PacketConn
-	status service.LifeCycle
+	serverList map[string]*ServerInfo
+	quotas     quotas
+	natRules   []*natRule
+	snapshot   atomic.Value
+	generation uint32
+
+	// dirty is set while registry writes are waiting to be published
+	dirty bool
+
+	// advertisePolled lists servers only known through other masters in the udp server list
+	advertisePolled bool
+
+	listeners   listeners
+	bans        banStore
+	allowlist   allowlist
+	namePolicy  namePolicy
+	status      service.LifeCycle
+	verifier    verifier
+	ingress     ingress
+	rateLimiter rateLimiter
+
+	responseBudget responseBudget
+	listCache      listCache
+	sweeper        sweeper
+	registrySave   sync.Mutex
 
 	services struct {
 		Map      *map[service.ID]service.Interface
@@ -45,6 +64,7 @@ type Service struct {
 		Heartbeat    *log.Log
 		Registration *log.Log
 		Banned       *log.Log
+		NamePolicy   *log.Log
 	}
 
 	masters struct {
@@ -54,6 +74,7 @@ type Service struct {
 
 	service.Interface
 	service.Maintainable
+	service.Localizable
 }
 
 type ServerInfo struct {
@@ -61,6 +82,24 @@ type ServerInfo struct {
 	*server.Server
 
 	SolicitedTime time.Time
+	Restored      bool
+
+	// servers that fail a re-query stay listed as stale until MaxFailures probes in a row have failed
+	FailedProbes int
+	Stale        bool
+	NextProbe    time.Time
+	Flaps        int
+	LastFlap     time.Time
+
+	// MaskedName replaces the server name in the http api when a name policy mask rule matched
+	MaskedName string
+
+	// Pinned servers are always advertised first and never removed
+	Pinned bool
+
+	// Origin is where the server came from, OriginMaster is the master it was polled from
+	Origin       string
+	OriginMaster string
 }
 
 func (s *Service) Init(services *map[service.ID]service.Interface) (err error) {
@@ -68,9 +107,11 @@ func (s *Service) Init(services *map[service.ID]service.Interface) (err error) {
 	s.masters.Main = protocol.NewMaster()
 	s.masters.Banned = protocol.NewMaster()
 	s.Options = &protocol.Options{}
-	s.ServerList = make(map[string]*ServerInfo)
+	s.serverList = make(map[string]*ServerInfo)
+	s.bans.bans = make(map[string]*Ban)
 
-	s.IPServiceCount = make(map[string]uint16)
+	s.rateLimiter.buckets = make(map[rateLimitKey]*tokenBucket)
+	s.rateLimiter.offenders = make(map[string]*offender)
 
 	s.services.Map = services
 	s.services.Config = (*s.services.Map)[service.Config].(*config.Service)
@@ -82,75 +123,58 @@ func (s *Service) Init(services *map[service.ID]service.Interface) (err error) {
 	s.logs.Heartbeat = (*s.services.Map)[service.Log].(*log.Service).NewLogger(service.HeartbeatLog)
 	s.logs.Registration = (*s.services.Map)[service.Log].(*log.Service).NewLogger(service.ServerRegistrationLog)
 	s.logs.Banned = (*s.services.Map)[service.Log].(*log.Service).NewLogger(service.BannedTrafficLog)
+	s.logs.NamePolicy = (*s.services.Map)[service.Log].(*log.Service).NewLogger(service.NamePolicyLog)
 
+	s.initResponseBudget()
 	s.Rehash()
+	s.startVerifiers()
 
-	return
-}
-
-func (s *Service) Run() {
-	// start listening loop
-	buf := make([]byte, s.services.Config.Values.Advanced.Network.MaxPacketSize)
-	buf2 := make([]byte, s.services.Config.Values.Advanced.Network.MaxPacketSize)
-	prevIPPort := ""
-	s.status = service.Running
-	addrPort := fmt.Sprintf("%s:%d", s.services.Config.Values.Service.Listen.IP, s.services.Config.Values.Service.Listen.Port)
-
-	err := s.openPort(addrPort)
+	restored, err := s.loadRegistry()
 	if err != nil {
-		s.logs.Master.LogAlertf("unable to bind to %s - [%w]", addrPort, err)
-		return
+		s.logs.Master.LogAlertf("unable to load registry file %s [%s]", s.services.Config.Values.Service.Registry.File, err)
+		err = nil
 	}
 
-	for {
-		n, addr, err := s.pconn.ReadFrom(buf)
-		if err != nil {
-			var e *net.OpError
-			if errors.As(err, &e) && e.Op == "read" {
-				s.logs.Master.LogAlertf("socket closed.")
-			} else {
-				s.logs.Master.LogAlertf("read error on socket [%w]", err)
-			}
+	if len(restored) > 0 {
+		go s.verifyRestoredServers(restored)
+	}
 
-			break
-		}
+	return
+}
 
-		// dedupe packets because udp
-		if prevIPPort == addr.String() && bytes.Equal(buf[:n], buf2[:n]) {
-			prevIPPort = ""
-			continue
-		}
+func (s *Service) Run() {
+	s.status = service.Running
+	s.startVerifiers()
+	s.startIngress()
 
-		copy(buf2, buf)
+	// each listen endpoint gets its own socket and read loop, block until they are shut down
+	quit, opened := s.startListeners()
+	if opened == 0 {
+		s.closeListeners()
+		s.stopIngress()
 
-		prevIPPort = addr.String()
+		s.status = service.Stopped
+		s.logs.Master.LogAlertf("service %s, unable to bind any of %s", s.status, s.listenEndpoints())
 
-		go s.serveMaster(&addr, buf[:n])
+		return
 	}
 
+	<-quit
+
 	s.status = service.Stopped
 	s.logs.Master.LogAlertf("service %s", s.status)
 }
 
 func (s *Service) Maintenance() {
-	count := 0
-	checked := 0
-	fresh := 0
-
-	for k := range s.ServerList {
-		removed, queried := s.CheckRemoveServer(k)
-
-		switch {
-		case removed: // removed && *
-			count++
-		case queried: // !removed && queried
-			checked++
-		case !queried: // !removed && !queried
-			fresh++
-		}
-	}
-
-	s.logs.Master.Logf("{%s} removed %d stale servers, queried %d servers, %d servers still fresh\n", service.Maintenance, count, checked, fresh)
+	// the sweep saves the registry once it has finished
+	s.startSweep()
+
+	s.maintainIngress()
+	s.maintainRateLimiter()
+	s.maintainResponseBudget()
+	s.maintainBans()
+	s.maintainAllowlist()
+	s.maintainNamePolicy()
 }
 
 func (s *Service) Rehash() {
@@ -174,19 +198,66 @@ func (s *Service) Rehash() {
 	s.Options.MaxServerPacketSize = s.services.Config.Values.Advanced.Network.MaxPacketSize
 	s.Options.MaxNetworkPacketSize = s.services.Config.Values.Advanced.Network.MaxBufferSize
 	s.Options.Timeout = s.services.Config.Values.Advanced.Network.ConnectionTimeout.Duration
-	s.Options.LocalNetworks = s.services.STUN.LocalAddresses
+	s.Options.LocalNetworks = s.services.STUN.LocalAddresses()
+	s.Options.ExternalIP = s.externalIP()
+	s.natRules = s.parseNATRules()
+	s.advertisePolled = s.services.Config.Values.Poll.AdvertisePolled
+	s.rehashQuotas()
+
+	// localized server list entries depend on the options
+	s.generation++
+	s.publish()
 	s.Unlock()
 
+	if err := s.loadBans(); err != nil {
+		s.logs.Banned.LogAlertf("{%s} unable to load ban file %s [%s]", service.Rehash, s.services.Config.Values.Service.Banned.File, err)
+	}
+
+	s.rehashPinned()
+	s.enforceQuotas()
+	s.rehashAllowlist()
+	s.rehashNamePolicy()
+	s.rehashVerifiers()
+	s.rehashIngress()
+	s.syncListeners()
+
 	s.status = p
 }
 
+// Relocalize picks up a new external ip address and local networks, bumping the generation so every
+// cached udp server list is built again
+func (s *Service) Relocalize() {
+	s.Lock()
+	s.Options.LocalNetworks = s.services.STUN.LocalAddresses()
+	s.Options.ExternalIP = s.externalIP()
+	s.generation++
+	s.publish()
+	s.Unlock()
+}
+
+// externalIP returns the STUN address in the form the list options expect, the list format only carries IPv4
+func (s *Service) externalIP() net.IP {
+	ip := net.ParseIP(s.services.STUN.Get(""))
+	if ip == nil || ip.To4() == nil {
+		return nil
+	}
+
+	return ip.To4()
+}
+
 func (s *Service) Shutdown() {
 	s.status = service.Stopping
 
-	if err := s.pconn.Close(); err != nil {
-		s.logs.Master.LogAlertf("{%s} error while closing socket [%s]", service.Shutdown, err)
+	if saved, err := s.SaveRegistry(); err != nil {
+		s.logs.Master.LogAlertf("{%s} unable to save registry file [%s]", service.Shutdown, err)
+	} else {
+		s.logs.Master.Logf("{%s} saved %d servers to registry file", service.Shutdown, saved)
 	}
 
+	s.closeListeners()
+	s.stopIngress()
+	s.stopVerifiers()
+
 	s.status = service.Stopped
 }
 
@@ -197,53 +268,135 @@ func (s *Service) Status() service.LifeCycle {
 func (s *Service) CheckRemoveServer(ipPort string) (removed bool, queried bool) {
 	removed = false
 	queried = false
-	svr := s.ServerList[ipPort]
-	addr := svr.Server.Address.(*net.UDPAddr)
 
-	if svr.IsExpired(s.services.Config.Values.Service.ServerTTL.Duration) {
-		err := svr.Query()
-		queried = true
+	svr, ok := s.Snapshot().Servers[ipPort]
+	if !ok {
+		return
+	}
 
-		if err != nil {
-			s.Lock()
-			s.IPServiceCount[addr.String()]--
+	now := time.Now()
+
+	// restored servers are always re-queried before they are trusted again, stale servers wait for their retry
+	due := svr.Restored || svr.IsExpired(s.services.Config.Values.Service.ServerTTL.Duration)
+	if svr.Stale {
+		due = !now.Before(svr.NextProbe)
+	}
+
+	if !due {
+		return
+	}
+
+	s.Lock()
+	options := *s.Options
+	s.Unlock()
 
-			if s.IPServiceCount[addr.String()] <= 0 {
-				delete(s.IPServiceCount, addr.String())
-			}
+	// the published entry is shared with readers, so the response goes into a fresh query
+	response := query.NewPingInfoQueryWithOptions(ipPort, &options)
+	err := response.Query()
+	queried = true
 
-			s.logs.Master.Logf("removing server %s, last seen: %s, new count for ip: %d", ipPort, svr.LastSeen.Format(time.Stamp), s.IPServiceCount[addr.String()])
-			delete(s.ServerList, ipPort)
-			delete(s.masters.Main.Servers, ipPort)
-			s.Unlock()
+	if err != nil {
+		failures := svr.FailedProbes + 1
+		maxFailures := s.services.Config.Values.Service.Probe.MaxFailures
+
+		// restored servers were never listed, so they don't get any retries. pinned servers are never removed
+		if !svr.Pinned && (svr.Restored || failures >= maxFailures) {
+			remaining := s.deleteServer(ipPort)
+			s.logs.Master.Logf("removing server %s, last seen: %s, failed probes: %d, new count for ip: %d", ipPort, svr.LastSeen.Format(time.Stamp), failures, remaining)
 
 			removed = true
 
 			return
 		}
 
-		svr.LastSeen = time.Now()
-		svr.SolicitedTime = time.Now()
+		backoff := s.probeBackoff(svr, failures, now)
+
+		s.updateServer(ipPort, false, func(info *ServerInfo) {
+			info.FailedProbes = failures
+			info.Stale = true
+			info.NextProbe = now.Add(backoff)
+		})
+
+		if svr.Pinned {
+			s.logs.Master.ServerAlertf(ipPort, "probe %d failed, pinned server listed as stale, retrying in %s [%s]", failures, backoff, err)
+		} else {
+			s.logs.Master.ServerAlertf(ipPort, "probe %d/%d failed, listed as stale, retrying in %s [%s]", failures, maxFailures, backoff, err)
+		}
+
+		return
+	}
+
+	// pinned servers are configured by hand, so the name policy and quotas don't apply to them
+	masked, rejected := "", false
+	if !svr.Pinned {
+		masked, rejected = s.applyNamePolicy(ipPort, string(response.Name))
+	}
+
+	if rejected {
+		remaining := s.deleteServer(ipPort)
+		s.logs.Master.Logf("removing server %s, rejected by the name policy, new count for ip: %d", ipPort, remaining)
+
+		removed = true
+
+		return
+	}
+
+	// quotas are enforced on rehash, this catches servers that registered while the limits were being lowered
+	if !svr.Pinned {
+		if usage, exceeded := s.overQuota(ipPort); exceeded != "" {
+			remaining := s.deleteServer(ipPort)
+			s.logs.Registration.ServerAlertf(ipPort, "Removing server over the per %s quota - IP: %d/%d, subnet %s: %d/%s, new count for ip: %d",
+				exceeded, usage.IPCount, usage.IPLimit, usage.Subnet, usage.SubnetCount, quotaLimit(usage.SubnetLimit), remaining)
+
+			removed = true
+
+			return
+		}
 	}
 
+	s.updateServer(ipPort, false, func(info *ServerInfo) {
+		s.recoverServer(ipPort, info, now)
+
+		info.PingInfoQuery = response
+		info.MaskedName = masked
+		info.LastSeen = now
+		info.SolicitedTime = now
+		info.Restored = false
+	})
+
 	return
 }
 
-func (s *Service) RegisterExternalServerList(servers map[string]*server.Server) (errs []error) {
+// RegisterExternalServerList verifies servers polled from other masters, origins maps each server to
+// the master it was polled from
+func (s *Service) RegisterExternalServerList(servers map[string]*server.Server, origins map[string]string) (errs []error) {
 	s.logs.Master.Logf("registering %d servers from external list", len(servers))
 
+	known := s.Snapshot().Servers
+	skipped := 0
+
 	for k := range servers {
+		// private masters only list approved servers
+		if !s.isAllowlisted(k) {
+			skipped++
+			continue
+		}
+
 		// only add servers we don't already know about
-		if _, ok := s.ServerList[k]; !ok {
-			s.registerHeartbeat(&servers[k].Address, k)
+		if _, ok := known[k]; !ok {
+			s.registerHeartbeat(&servers[k].Address, k, OriginPoll, origins[k])
 		}
 	}
 
+	if skipped > 0 {
+		s.logs.Registration.Logf("skipped %d servers from external list not on the allowlist", skipped)
+	}
+
 	return
 }
 
 func (s *Service) RegisterExternalServer(ipPort string) error {
-	if _, ok := s.ServerList[ipPort]; ok {
+	if _, ok := s.Snapshot().Servers[ipPort]; !ok {
 		// only query new servers
 		addr, err := net.ResolveUDPAddr("udp", ipPort)
 		if err != nil {
@@ -251,37 +404,30 @@ func (s *Service) RegisterExternalServer(ipPort string) error {
 		}
 
 		addr2 := net.Addr(addr)
-		s.registerHeartbeat(&addr2, ipPort)
+		s.registerHeartbeat(&addr2, ipPort, OriginHeartbeat, "")
 	}
 
 	return nil
 }
 
-func (s *Service) openPort(addrPort string) (err error) {
-	s.pconn, err = net.ListenPacket("udp", addrPort)
-	if err != nil {
+func (s *Service) serveMaster(conn net.PacketConn, addr *net.Addr, buf []byte) {
+	ipNet, ok := (*addr).(*net.UDPAddr)
+	if !ok {
+		s.logs.Master.LogAlertf("Error parsing IP")
 		return
 	}
 
-	s.logs.Master.Logf("now listening on [%s]", fmt.Sprintf("%s:%d", s.services.STUN.Get(""), s.services.Config.Values.Service.Listen.Port))
-
-	return
-}
-
-func (s *Service) serveMaster(addr *net.Addr, buf []byte) {
-	ipNet := (*addr).(*net.UDPAddr)
+	// IPv4 clients on a dual-stack socket arrive as IPv4-mapped IPv6 addresses
+	ipNet = normalizeUDPAddr(ipNet)
+	normalized := net.Addr(ipNet)
+	addr = &normalized
 
 	// we use an ip-port combo as a unique identifier
-	host, port, err := net.SplitHostPort((*addr).String())
-	if err != nil {
-		s.logs.Master.LogAlertf("Error parsing IP")
-	}
-
-	ipPort := fmt.Sprintf("%s:%s", host, port)
+	ipPort := ipNet.String()
 
 	// parse packet
 	p := protocol.NewPacket()
-	err = p.UnmarshalBinary(buf)
+	err := p.UnmarshalBinary(buf)
 
 	if err != nil {
 		switch {
@@ -296,125 +442,107 @@ func (s *Service) serveMaster(addr *net.Addr, buf []byte) {
 		return
 	}
 
-	isBanned := false
-
-	for _, v := range s.services.Config.ParsedBannedNets {
-		if v.Contains(ipNet.IP) {
-			s.logs.Banned.ServerAlertf(ipNet.IP.String(), "Received a %s packet from banned host", p.Type.String())
-
-			if p.Type != protocol.PingInfoQuery {
-				return
-			}
+	// packets we don't handle are dropped before they can use up the query budget
+	if p.Type != protocol.MasterServerHeartbeat && p.Type != protocol.PingInfoQuery {
+		s.logs.Master.ServerAlertf(ipPort, "Received unsolicited packet type %s", p.Type.String())
+		return
+	}
 
-			isBanned = true
+	allowed, isBanned, ban := s.admitPacket(ipNet, p.Type, p.Type.String())
+	if !allowed {
+		return
+	}
 
-			break
-		}
+	// banned clients are sent the banned message, anything else from a banned host is dropped
+	if isBanned && p.Type != protocol.PingInfoQuery {
+		return
 	}
 
 	switch p.Type {
 	// server has sent in a heartbeat
 	case protocol.MasterServerHeartbeat:
-		s.registerHeartbeat(addr, ipPort)
+		if !s.isAllowlisted(ipPort) {
+			s.rejectHeartbeat(ipPort)
+			return
+		}
+
+		s.registerHeartbeat(addr, ipPort, OriginHeartbeat, "")
 
 	// client is requesting a server list
 	case protocol.PingInfoQuery:
 		if isBanned {
-			s.sendBanned(addr, ipPort, p)
+			s.sendBanned(conn, addr, ipPort, p, ban)
 			return
 		}
 
-		s.sendList(addr, ipPort, p)
-
-	default:
-		s.logs.Master.ServerAlertf(ipPort, "Received unsolicited packet type %s", p.Type.String())
+		s.sendList(conn, addr, ipPort, p)
 	}
 }
 
-func (s *Service) registerHeartbeat(addr *net.Addr, ipPort string) {
-	s.Lock()
+// admitPacket applies the rate limiter and the bans to a packet, logging traffic from banned hosts. allowed is
+// false if the host is over its rate limit, banned hosts are still rate limited so an answer can't be used for a flood
+func (s *Service) admitPacket(addr *net.UDPAddr, kind protocol.PacketType, description string) (allowed bool, isBanned bool, ban *Ban) {
+	ip := addr.IP
+	host := rateLimitHost(ip)
 
-	q := darkstar.NewQuery(s.Options.Timeout, s.Options.Debug)
-	q.Addresses = append(q.Addresses, ipPort)
-	response, err := q.Servers()
+	allowed = s.allowPacket(addr, kind)
+	ban = s.findBan(ip)
+	isBanned = ban != nil || s.isTemporarilyBanned(host)
 
-	if len(err) > 0 || len(response) == 0 {
-		s.logs.Heartbeat.ServerAlertf(ipPort, "error during server verification [%s, %d]", err, len(response))
-		s.Unlock()
+	for _, v := range s.services.Config.ParsedBannedNets {
+		if v.Contains(ip) {
+			isBanned = true
+			break
+		}
+	}
 
+	if !allowed || !isBanned {
 		return
 	}
 
-	// only add a server to the list if it passes verification
-	if _, ok := s.ServerList[ipPort]; !ok {
-		s.ServerList[ipPort] = new(ServerInfo)
-		s.ServerList[ipPort].PingInfoQuery = query.NewPingInfoQueryWithOptions(ipPort, s.Options)
-		s.ServerList[ipPort].Server = new(server.Server)
-		s.ServerList[ipPort].Server.Address = *addr
+	if ban != nil {
+		s.logs.Banned.ServerAlertf(ip.String(), "Received a packet from banned host, ban %s [%s]", ban.ID, ban.Reason)
+	} else {
+		s.logs.Banned.ServerAlertf(ip.String(), "Received a %s packet from banned host", description)
 	}
 
-	s.ServerList[ipPort].SolicitedTime = time.Now()
-	s.ServerList[ipPort].LastSeen = time.Now()
-	s.ServerList[ipPort].PingInfoQuery = response[0]
-
-	go s.services.Stats.UpdatePlayerCountForServer(ipPort, response[0].PlayerCount)
-
-	s.Unlock()
-	s.registerPingInfo(addr, ipPort)
+	return
 }
 
 func (s *Service) registerPingInfo(addr *net.Addr, ipPort string) {
-	s.Lock()
-	ipNet := (*addr).(*net.UDPAddr)
-
-	if _, ok := s.masters.Main.Servers[ipPort]; !ok {
-		count := s.IPServiceCount[ipNet.IP.String()]
-		if count+1 > s.services.Config.Values.Service.ServersPerIP {
-			s.logs.Registration.ServerAlertf(ipPort, "Rejecting additional server for IP - count: %d/%d", count, s.services.Config.Values.Service.ServersPerIP)
-			s.Unlock()
-
-			return
-		}
-
-		// log and add new
-		s.masters.Main.Servers[ipPort] = &server.Server{
-			Address:    *addr,
-			Connection: &s.pconn,
-			LastSeen:   time.Now(),
-		}
+	usage, added, lastSeen, exceeded := s.advertiseServer(*addr, ipPort)
+	if exceeded != "" {
+		s.logs.Registration.ServerAlertf(ipPort, "Rejecting additional server over the per %s quota - IP: %d/%d, subnet %s: %d/%s",
+			exceeded, usage.IPCount, usage.IPLimit, usage.Subnet, usage.SubnetCount, quotaLimit(usage.SubnetLimit))
 
-		count++
-		s.logs.Registration.ServerLogf(ipPort, "New Server for IP - total server count for IP: %d/%d", count, s.services.Config.Values.Service.ServersPerIP)
-		s.IPServiceCount[ipNet.IP.String()] = count
+		return
 	}
 
-	LastSeen := s.masters.Main.Servers[ipPort].LastSeen
-	s.masters.Main.Servers[ipPort].LastSeen = time.Now()
+	if added {
+		s.logs.Registration.ServerLogf(ipPort, "New Server for IP - total server count for IP: %d/%d, subnet %s: %d/%s",
+			usage.IPCount, usage.IPLimit, usage.Subnet, usage.SubnetCount, quotaLimit(usage.SubnetLimit))
+	}
 
-	s.logs.Heartbeat.ServerLogf(ipPort, "Heartbeat - delta: %s", time.Since(LastSeen).String())
-	s.Unlock()
+	s.logs.Heartbeat.ServerLogf(ipPort, "Heartbeat - delta: %s", time.Since(lastSeen).String())
 }
 
-func (s *Service) sendList(addr *net.Addr, ipPort string, p *protocol.Packet) {
-	var laddr net.Addr
+// sendList replies on the socket the request arrived on, so the client sees the address it sent to
+func (s *Service) sendList(conn net.PacketConn, addr *net.Addr, ipPort string, p *protocol.Packet) {
+	host, _, _ := net.SplitHostPort(ipPort)
 
-	for _, v := range s.services.STUN.LocalAddresses {
-		if v.Contains((*addr).(*net.UDPAddr).IP) {
-			laddr = &net.IPAddr{
-				IP:   v.IP,
-				Zone: "",
-			}
+	// the server entries only change with the registry, only the header is built per request
+	snap := s.Snapshot()
+	entries := s.listEntries(snap, s.localViewAddress(*addr), *addr)
+	output := s.applyResponseBudget(host, p, snap, s.services.Template.Get(host), entries)
 
-			break
-		}
-	}
+	s.recordAmplification(protocol.HeaderSize+len(p.Data), output)
 
-	host, _, _ := net.SplitHostPort(ipPort)
-	s.masters.Main.MOTD = s.services.Template.Get(host)
-	output := s.masters.Main.GeneratePackets(s.Options, p.Key, laddr, *addr)
+	if len(output) == 0 {
+		return
+	}
 
 	for _, v := range output {
-		_, err := s.pconn.WriteTo(v, *addr)
+		_, err := conn.WriteTo(v, *addr)
 		if err != nil {
 			s.logs.Master.ServerAlertf(ipPort, "error sending master list [%s]", err)
 			return
@@ -424,12 +552,21 @@ func (s *Service) sendList(addr *net.Addr, ipPort string, p *protocol.Packet) {
 	s.logs.Master.ServerLogf(ipPort, "servers list sent")
 }
 
-func (s *Service) sendBanned(addr *net.Addr, ipPort string, p *protocol.Packet) {
-	m := s.masters.Banned
-	output := m.GeneratePackets(s.Options, p.Key, nil, nil)
+func (s *Service) sendBanned(conn net.PacketConn, addr *net.Addr, ipPort string, p *protocol.Packet, ban *Ban) {
+	s.Lock()
+	m := *s.masters.Banned
+	options := *s.Options
+	s.Unlock()
+
+	// bans from the ban store can carry their own message
+	if ban != nil && ban.MOTD != "" {
+		m.MOTD = ban.MOTD
+	}
+
+	output := m.GeneratePackets(&options, p.Key, nil, nil)
 
 	for _, v := range output {
-		_, err := s.pconn.WriteTo(v, *addr)
+		_, err := conn.WriteTo(v, *addr)
 		if err != nil {
 			s.logs.Banned.ServerAlertf(ipPort, "error sending master list [%s]", err)
 			return
@@ -0,0 +1,196 @@
+package master
+
+import (
+	"net"
+	"time"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/protocol"
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/server"
+)
+
+// Snapshot is a point in time copy of the registry, it is shared between all readers and must never be modified
+type Snapshot struct {
+	Created    time.Time
+	Servers    map[string]*ServerInfo
+	Advertised map[string]*server.Server
+
+	commonName string
+	masterID   uint16
+	options    protocol.Options
+	natRules   []*natRule
+	generation uint32
+
+	advertisePolled bool
+}
+
+// publishDelay is how long registry writes are collected before a new snapshot is published, so a burst of
+// heartbeats copies the registry once rather than once per heartbeat
+const publishDelay = 100 * time.Millisecond
+
+// Snapshot returns the most recently published copy of the registry, it never blocks on writers.
+// writes show up within publishDelay
+func (s *Service) Snapshot() *Snapshot {
+	if snap, ok := s.snapshot.Load().(*Snapshot); ok {
+		return snap
+	}
+
+	return &Snapshot{
+		Created:    time.Now(),
+		Servers:    make(map[string]*ServerInfo),
+		Advertised: make(map[string]*server.Server),
+	}
+}
+
+// currentSnapshot publishes any pending writes before returning the snapshot, for readers which need every
+// write made so far. it must not be called with the registry lock held
+func (s *Service) currentSnapshot() *Snapshot {
+	s.Lock()
+	if s.dirty {
+		s.publish()
+	}
+	s.Unlock()
+
+	return s.Snapshot()
+}
+
+// markDirty schedules a publish for a registry write, it expects the registry lock to be held
+func (s *Service) markDirty() {
+	if s.dirty {
+		return
+	}
+
+	s.dirty = true
+
+	time.AfterFunc(publishDelay, func() {
+		s.Lock()
+		if s.dirty {
+			s.publish()
+		}
+		s.Unlock()
+	})
+}
+
+// publish copies the registry into a new snapshot straight away, it expects the registry lock to be held
+func (s *Service) publish() {
+	s.dirty = false
+
+	snap := &Snapshot{
+		Created:    time.Now(),
+		Servers:    make(map[string]*ServerInfo, len(s.serverList)),
+		Advertised: make(map[string]*server.Server, len(s.masters.Main.Servers)),
+		commonName: s.masters.Main.CommonName,
+		masterID:   s.masters.Main.MasterID,
+		options:    *s.Options,
+		natRules:   s.natRules,
+		generation: s.generation,
+
+		advertisePolled: s.advertisePolled,
+	}
+
+	// entries are replaced rather than modified once stored, so sharing the pointers is safe
+	for k, v := range s.serverList {
+		snap.Servers[k] = v
+	}
+
+	for k, v := range s.masters.Main.Servers {
+		snap.Advertised[k] = v
+	}
+
+	s.snapshot.Store(snap)
+}
+
+// clone returns a copy of the entry which can be modified and then stored in place of the original
+func (i *ServerInfo) clone() *ServerInfo {
+	out := *i
+
+	if i.Server != nil {
+		svr := *i.Server
+		out.Server = &svr
+	}
+
+	return &out
+}
+
+// updateServer replaces the registry entry of a server with an updated copy, missing entries are only
+// created if create is set
+func (s *Service) updateServer(ipPort string, create bool, update func(info *ServerInfo)) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	info := &ServerInfo{
+		Server: new(server.Server),
+	}
+
+	if existing, ok := s.serverList[ipPort]; ok {
+		info = existing.clone()
+	} else if !create {
+		return false
+	}
+
+	update(info)
+
+	s.serverList[ipPort] = info
+	s.markDirty()
+
+	return true
+}
+
+// deleteServer removes a server from the registry and the advertised list,
+// returning the number of servers still advertised for its IP
+func (s *Service) deleteServer(ipPort string) (remaining uint16) {
+	s.Lock()
+	defer s.Unlock()
+
+	host, _, _ := net.SplitHostPort(ipPort)
+
+	// restored servers that never passed verification were never counted
+	if _, ok := s.masters.Main.Servers[ipPort]; ok {
+		remaining = s.removeQuota(host)
+
+		delete(s.masters.Main.Servers, ipPort)
+		s.generation++
+	} else {
+		remaining = s.quotas.perIP[host]
+	}
+
+	delete(s.serverList, ipPort)
+	s.markDirty()
+
+	return remaining
+}
+
+// advertiseServer adds a verified server to the advertised list if its IP and subnet are within their quotas,
+// known servers only have their last seen time refreshed. a server rejected by its quota is dropped from the registry
+// as well, so it isn't kept around and re-probed without ever being listed
+func (s *Service) advertiseServer(addr net.Addr, ipPort string) (usage QuotaUsage, added bool, lastSeen time.Time, exceeded string) {
+	s.Lock()
+	defer s.Unlock()
+
+	host, _, _ := net.SplitHostPort(ipPort)
+	usage = s.quotaUsage(host)
+
+	existing, known := s.masters.Main.Servers[ipPort]
+	if !known {
+		if exceeded = usage.exceededBy(1); exceeded != "" {
+			delete(s.serverList, ipPort)
+			s.markDirty()
+
+			return usage, false, time.Time{}, exceeded
+		}
+
+		s.addQuota(&usage)
+		s.generation++
+
+		existing = &server.Server{
+			Address:  addr,
+			LastSeen: time.Now(),
+		}
+	}
+
+	svr := *existing
+	svr.LastSeen = time.Now()
+	s.masters.Main.Servers[ipPort] = &svr
+	s.markDirty()
+
+	return usage, !known, existing.LastSeen, ""
+}
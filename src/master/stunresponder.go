@@ -0,0 +1,68 @@
+package master
+
+import (
+	"net"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/protocol"
+	"github.com/pion/stun"
+)
+
+// isSTUNPacket checks for the STUN magic cookie at bytes 4 to 8, where darkstar packets carry their key and id.
+// a darkstar packet could carry the cookie by chance, so packets starting with a darkstar protocol version are
+// never taken for STUN
+func (s *Service) isSTUNPacket(buf []byte) bool {
+	if !s.services.Config.Values.Service.StunResponder.Enabled || !stun.IsMessage(buf) {
+		return false
+	}
+
+	return buf[0] != protocol.Version && buf[0] != protocol.VersionExt
+}
+
+// serveSTUN answers RFC 5389 binding requests with the address a request was sent from, so servers
+// behind a nat can learn their public address from the master they already send heartbeats to
+func (s *Service) serveSTUN(conn net.PacketConn, addr net.Addr, buf []byte) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return
+	}
+
+	udpAddr = normalizeUDPAddr(udpAddr)
+
+	// binding requests are rate limited as server list queries, banned hosts are ignored
+	if allowed, isBanned, _ := s.admitPacket(udpAddr, protocol.PingInfoQuery, "STUN"); !allowed || isBanned {
+		return
+	}
+
+	request := new(stun.Message)
+	if err := stun.Decode(buf, request); err != nil {
+		s.logs.Master.ServerAlertf(udpAddr.String(), "Error while parsing STUN packet [%s]", err)
+		return
+	}
+
+	if request.Type != stun.BindingRequest {
+		return
+	}
+
+	response, err := stun.Build(
+		stun.NewTransactionIDSetter(request.TransactionID),
+		stun.BindingSuccess,
+		&stun.XORMappedAddress{
+			IP:   udpAddr.IP,
+			Port: udpAddr.Port,
+		},
+		stun.Fingerprint,
+	)
+	if err != nil {
+		s.logs.Master.ServerAlertf(udpAddr.String(), "Error while building STUN response [%s]", err)
+		return
+	}
+
+	if _, err = conn.WriteTo(response.Raw, udpAddr); err != nil {
+		s.logs.Master.ServerAlertf(udpAddr.String(), "Error while sending STUN response [%s]", err)
+		return
+	}
+
+	s.ingress.Lock()
+	s.ingress.counters.stun++
+	s.ingress.Unlock()
+}
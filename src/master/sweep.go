@@ -0,0 +1,172 @@
+package master
+
+import (
+	"sync"
+	"time"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+)
+
+type sweeper struct {
+	sync.Mutex
+
+	running bool
+	started time.Time
+	sweeps  uint64
+	skipped uint64
+	last    SweepResult
+}
+
+type SweepResult struct {
+	Started  time.Time
+	Duration time.Duration
+	Servers  int
+	Removed  int
+	Queried  int
+	Fresh    int
+	Deferred int
+	TimedOut bool
+}
+
+type SweepStats struct {
+	Running      bool
+	RunningSince time.Time
+	Workers      int
+	Timeout      time.Duration
+	Sweeps       uint64
+	Skipped      uint64
+	Last         SweepResult
+}
+
+// startSweep checks every known server in the background, a tick is skipped while the previous sweep is still running
+func (s *Service) startSweep() {
+	w := &s.sweeper
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.running {
+		w.skipped++
+		s.logs.Master.LogAlertf("{%s} previous sweep started %s ago is still running, skipping", service.Maintenance, time.Since(w.started).Round(time.Second))
+
+		return
+	}
+
+	w.running = true
+	w.started = time.Now()
+
+	go s.sweep(w.started)
+}
+
+// sweep re-queries expired servers with bounded concurrency, servers not handed to a worker before
+// the sweep deadline are deferred to the next run
+func (s *Service) sweep(started time.Time) {
+	cfg := &s.services.Config.Values.Advanced.Maintenance
+
+	workers := cfg.SweepWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var deadline <-chan time.Time
+	if cfg.SweepTimeout.Duration > 0 {
+		timer := time.NewTimer(cfg.SweepTimeout.Duration)
+		defer timer.Stop()
+
+		deadline = timer.C
+	}
+
+	servers := s.Snapshot().Servers
+	result := SweepResult{
+		Started: started,
+		Servers: len(servers),
+	}
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	jobs := make(chan string)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for ipPort := range jobs {
+				removed, queried := s.CheckRemoveServer(ipPort)
+
+				mu.Lock()
+				switch {
+				case removed: // removed && *
+					result.Removed++
+				case queried: // !removed && queried
+					result.Queried++
+				case !queried: // !removed && !queried
+					result.Fresh++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for ipPort := range servers {
+		if result.TimedOut {
+			result.Deferred++
+			continue
+		}
+
+		select {
+		case jobs <- ipPort:
+		case <-deadline:
+			result.TimedOut = true
+			result.Deferred++
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	result.Duration = time.Since(started)
+
+	s.logs.Master.Logf("{%s} swept %d servers in %s: removed %d stale servers, queried %d servers, %d servers still fresh, %d deferred\n",
+		service.Maintenance, result.Servers, result.Duration.Round(time.Millisecond), result.Removed, result.Queried, result.Fresh, result.Deferred)
+
+	if result.TimedOut {
+		s.logs.Master.LogAlertf("{%s} sweep deadline of %s reached, %d servers deferred to the next run", service.Maintenance, cfg.SweepTimeout.Duration, result.Deferred)
+	}
+
+	if _, err := s.SaveRegistry(); err != nil {
+		s.logs.Master.LogAlertf("{%s} unable to save registry file [%s]", service.Maintenance, err)
+	}
+
+	w := &s.sweeper
+
+	w.Lock()
+	w.running = false
+	w.sweeps++
+	w.last = result
+	w.Unlock()
+}
+
+// SweepStats returns the state of the stale server sweep
+func (s *Service) SweepStats() SweepStats {
+	w := &s.sweeper
+
+	w.Lock()
+	defer w.Unlock()
+
+	out := SweepStats{
+		Running: w.running,
+		Workers: s.services.Config.Values.Advanced.Maintenance.SweepWorkers,
+		Timeout: s.services.Config.Values.Advanced.Maintenance.SweepTimeout.Duration,
+		Sweeps:  w.sweeps,
+		Skipped: w.skipped,
+		Last:    w.last,
+	}
+
+	if w.running {
+		out.RunningSince = w.started
+	}
+
+	return out
+}
@@ -0,0 +1,251 @@
+package master
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2"
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/query"
+)
+
+type verifyRequest struct {
+	addr         net.Addr
+	ipPort       string
+	origin       string
+	originMaster string
+}
+
+type verifier struct {
+	sync.Mutex
+
+	queue     chan *verifyRequest
+	quit      chan struct{}
+	wg        sync.WaitGroup
+	inFlight  map[string]bool
+	workers   int
+	queueSize int
+
+	// counters are guarded by the lock, 64-bit atomics fault on 32-bit platforms unless they are aligned
+	counters struct {
+		enqueued uint64
+		deduped  uint64
+		dropped  uint64
+		verified uint64
+		failed   uint64
+	}
+}
+
+type VerificationStats struct {
+	Workers    int
+	QueueSize  int
+	QueueDepth int
+	InFlight   int
+	Enqueued   uint64
+	Deduped    uint64
+	Dropped    uint64
+	Verified   uint64
+	Failed     uint64
+}
+
+// startVerifiers spins up the heartbeat verification worker pool using the current config values
+func (s *Service) startVerifiers() {
+	v := &s.verifier
+
+	v.Lock()
+	defer v.Unlock()
+
+	if v.quit != nil {
+		return
+	}
+
+	v.workers = s.services.Config.Values.Advanced.Verification.Workers
+	if v.workers <= 0 {
+		v.workers = 1
+	}
+
+	v.queueSize = s.services.Config.Values.Advanced.Verification.QueueSize
+	if v.queueSize <= 0 {
+		v.queueSize = 1
+	}
+
+	v.queue = make(chan *verifyRequest, v.queueSize)
+	v.quit = make(chan struct{})
+	v.inFlight = make(map[string]bool)
+
+	for i := 0; i < v.workers; i++ {
+		v.wg.Add(1)
+
+		go s.verificationWorker(v.queue, v.quit)
+	}
+
+	s.logs.Heartbeat.Logf("started %d verification workers, queue size %d", v.workers, v.queueSize)
+}
+
+// stopVerifiers stops the worker pool, any heartbeats still queued are discarded
+func (s *Service) stopVerifiers() {
+	v := &s.verifier
+
+	v.Lock()
+	if v.quit == nil {
+		v.Unlock()
+		return
+	}
+
+	close(v.quit)
+	v.quit = nil
+	pending := len(v.queue)
+	v.queue = nil
+	v.Unlock()
+
+	v.wg.Wait()
+
+	if pending > 0 {
+		s.logs.Heartbeat.LogAlertf("discarded %d queued heartbeats", pending)
+	}
+}
+
+// rehashVerifiers restarts the worker pool if its configuration has changed
+func (s *Service) rehashVerifiers() {
+	v := &s.verifier
+
+	v.Lock()
+	running := v.quit != nil
+	changed := v.workers != s.services.Config.Values.Advanced.Verification.Workers || v.queueSize != s.services.Config.Values.Advanced.Verification.QueueSize
+	v.Unlock()
+
+	if running && changed {
+		s.stopVerifiers()
+		s.startVerifiers()
+	}
+}
+
+// VerificationStats returns the current counters of the heartbeat verification pipeline
+func (s *Service) VerificationStats() VerificationStats {
+	v := &s.verifier
+
+	v.Lock()
+	defer v.Unlock()
+
+	return VerificationStats{
+		Workers:    v.workers,
+		QueueSize:  v.queueSize,
+		QueueDepth: len(v.queue),
+		InFlight:   len(v.inFlight),
+		Enqueued:   v.counters.enqueued,
+		Deduped:    v.counters.deduped,
+		Dropped:    v.counters.dropped,
+		Verified:   v.counters.verified,
+		Failed:     v.counters.failed,
+	}
+}
+
+// registerHeartbeat queues a server for verification, heartbeats from servers
+// that are already being verified are ignored
+func (s *Service) registerHeartbeat(addr *net.Addr, ipPort string, origin string, originMaster string) {
+	v := &s.verifier
+
+	v.Lock()
+	defer v.Unlock()
+
+	if v.inFlight[ipPort] {
+		v.counters.deduped++
+		return
+	}
+
+	select {
+	case v.queue <- &verifyRequest{addr: *addr, ipPort: ipPort, origin: origin, originMaster: originMaster}:
+		v.inFlight[ipPort] = true
+		v.counters.enqueued++
+
+	default:
+		v.counters.dropped++
+		s.logs.Heartbeat.ServerAlertf(ipPort, "verification queue full, dropping heartbeat [%d/%d]", len(v.queue), v.queueSize)
+	}
+}
+
+func (s *Service) verificationWorker(queue chan *verifyRequest, quit chan struct{}) {
+	defer s.verifier.wg.Done()
+
+	for {
+		select {
+		case <-quit:
+			return
+
+		case req := <-queue:
+			s.verifyHeartbeat(req)
+		}
+	}
+}
+
+func (s *Service) verifyHeartbeat(req *verifyRequest) {
+	v := &s.verifier
+	verified := false
+
+	// the server is released even if committing it panics, a heartbeat that never commits counts as failed
+	defer func() {
+		v.Lock()
+		delete(v.inFlight, req.ipPort)
+
+		if verified {
+			v.counters.verified++
+		} else {
+			v.counters.failed++
+		}
+		v.Unlock()
+	}()
+
+	s.Lock()
+	q := darkstar.NewQuery(s.Options.Timeout, s.Options.Debug)
+	s.Unlock()
+
+	q.Addresses = append(q.Addresses, req.ipPort)
+	response, err := q.Servers()
+
+	if len(err) > 0 || len(response) == 0 {
+		s.logs.Heartbeat.ServerAlertf(req.ipPort, "error during server verification [%s, %d]", err, len(response))
+
+		return
+	}
+
+	s.commitHeartbeat(req, response[0])
+	verified = true
+}
+
+// commitHeartbeat adds or updates a server which has passed verification
+func (s *Service) commitHeartbeat(req *verifyRequest, response *query.PingInfoQuery) {
+	addr, ipPort := &req.addr, req.ipPort
+
+	// pinned servers are configured by hand, so the name policy doesn't apply to them
+	masked, rejected := "", false
+	if !s.isPinned(ipPort) {
+		masked, rejected = s.applyNamePolicy(ipPort, string(response.Name))
+	}
+
+	if rejected {
+		if _, ok := s.Snapshot().Servers[ipPort]; ok {
+			s.deleteServer(ipPort)
+		}
+
+		s.logs.Registration.ServerAlertf(ipPort, "Rejecting server, name rejected by the name policy")
+
+		return
+	}
+
+	s.updateServer(ipPort, true, func(info *ServerInfo) {
+		s.recoverServer(ipPort, info, time.Now())
+
+		info.Server.Address = *addr
+		info.SolicitedTime = time.Now()
+		info.LastSeen = time.Now()
+		info.PingInfoQuery = response
+		info.MaskedName = masked
+		info.Restored = false
+
+		s.updateOrigin(ipPort, info, req.origin, req.originMaster)
+	})
+
+	go s.services.Stats.UpdatePlayerCountForServer(ipPort, response.PlayerCount)
+
+	s.registerPingInfo(addr, ipPort)
+}
@@ -0,0 +1,154 @@
+package polling
+
+import (
+	"sort"
+	"time"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/query"
+)
+
+// ServerPresence lists the masters advertising a server, Local is set if this master advertises it
+type ServerPresence struct {
+	Address string
+	Local   bool
+	Masters []string
+}
+
+// MasterIDCollision lists masters sharing the same id, Local is set if this master uses it as well
+type MasterIDCollision struct {
+	MasterID uint16
+	Local    bool
+	Masters  []string
+}
+
+// ConsistencyReport compares the server lists of the polled masters with our own
+type ConsistencyReport struct {
+	Created      time.Time
+	Masters      []string
+	SingleMaster []ServerPresence
+	MissingHere  []ServerPresence
+	IDCollisions []MasterIDCollision
+}
+
+// buildConsistencyReport compares the polled masters with the servers we publish, in the form a client outside
+// our networks is sent them so our local servers match the addresses other masters list them under
+func (s *Service) buildConsistencyReport(masters []*query.MasterQuery, published map[string]bool) *ConsistencyReport {
+	report := &ConsistencyReport{
+		Created:      time.Now(),
+		Masters:      make([]string, 0, len(masters)),
+		SingleMaster: make([]ServerPresence, 0),
+		MissingHere:  make([]ServerPresence, 0),
+		IDCollisions: make([]MasterIDCollision, 0),
+	}
+
+	presence := make(map[string]*ServerPresence)
+	ids := make(map[uint16]*MasterIDCollision)
+
+	for k := range published {
+		presence[k] = &ServerPresence{
+			Address: k,
+			Local:   true,
+			Masters: make([]string, 0),
+		}
+	}
+
+	ids[s.services.Config.Values.Service.ID] = &MasterIDCollision{
+		MasterID: s.services.Config.Values.Service.ID,
+		Local:    true,
+	}
+
+	for _, m := range masters {
+		report.Masters = append(report.Masters, m.Address)
+
+		for k := range m.Servers {
+			p, ok := presence[k]
+			if !ok {
+				p = &ServerPresence{
+					Address: k,
+				}
+				presence[k] = p
+			}
+
+			p.Masters = append(p.Masters, m.Address)
+		}
+
+		c, ok := ids[m.MasterID]
+		if !ok {
+			c = &MasterIDCollision{
+				MasterID: m.MasterID,
+			}
+			ids[m.MasterID] = c
+		}
+
+		c.Masters = append(c.Masters, m.Address)
+	}
+
+	sort.Strings(report.Masters)
+
+	for _, p := range presence {
+		sort.Strings(p.Masters)
+
+		listedBy := len(p.Masters)
+		if p.Local {
+			listedBy++
+		}
+
+		// with nothing to compare against every server would be on a single master
+		if listedBy == 1 && len(masters) > 0 {
+			report.SingleMaster = append(report.SingleMaster, *p)
+		}
+
+		if !p.Local {
+			report.MissingHere = append(report.MissingHere, *p)
+		}
+	}
+
+	for _, c := range ids {
+		listedBy := len(c.Masters)
+		if c.Local {
+			listedBy++
+		}
+
+		if listedBy > 1 {
+			sort.Strings(c.Masters)
+			report.IDCollisions = append(report.IDCollisions, *c)
+		}
+	}
+
+	sort.Slice(report.SingleMaster, func(i, j int) bool {
+		return report.SingleMaster[i].Address < report.SingleMaster[j].Address
+	})
+
+	sort.Slice(report.MissingHere, func(i, j int) bool {
+		return report.MissingHere[i].Address < report.MissingHere[j].Address
+	})
+
+	sort.Slice(report.IDCollisions, func(i, j int) bool {
+		return report.IDCollisions[i].MasterID < report.IDCollisions[j].MasterID
+	})
+
+	return report
+}
+
+// logConsistencyReport writes a summary of a report, collisions are logged one by one as they need fixing
+func (s *Service) logConsistencyReport(report *ConsistencyReport) {
+	s.log.Logf("consistency across %d masters: %d servers listed by a single master, %d servers missing here, %d master id collisions",
+		len(report.Masters), len(report.SingleMaster), len(report.MissingHere), len(report.IDCollisions))
+
+	for _, c := range report.IDCollisions {
+		masters := c.Masters
+		if c.Local {
+			masters = append([]string{"this master"}, masters...)
+		}
+
+		s.log.LogAlertf("master id %d is used by %d masters %s", c.MasterID, len(masters), masters)
+	}
+}
+
+// ConsistencyReport returns the report built from the last poll, or nil if no poll has finished yet
+func (s *Service) ConsistencyReport() *ConsistencyReport {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.consistency
+}
@@ -0,0 +1,181 @@
+package polling
+
+import (
+	"sort"
+	"time"
+)
+
+// maxLatencyHistory is the number of recent successful query times kept per master
+const maxLatencyHistory = 10
+
+// MasterHealth tracks how a known master has been answering our polls
+type MasterHealth struct {
+	Address             string
+	ResolvedAddress     string `json:",omitempty"`
+	Attempts            int
+	Successes           int
+	ConsecutiveFailures int
+	LastAttempt         time.Time
+	LastSuccess         time.Time
+	LastError           string `json:",omitempty"`
+	NextAttempt         time.Time
+	Latency             []time.Duration
+	ServersContributed  int
+}
+
+// AverageLatency returns the mean of the recent query times, or 0 if the master never answered
+func (h *MasterHealth) AverageLatency() time.Duration {
+	if len(h.Latency) == 0 {
+		return 0
+	}
+
+	var total time.Duration
+	for _, v := range h.Latency {
+		total += v
+	}
+
+	return total / time.Duration(len(h.Latency))
+}
+
+// dueMasters returns the known masters that aren't backing off, records of masters that are no longer
+// known are dropped
+func (s *Service) dueMasters(now time.Time) (due []string, skipped int) {
+	known := make(map[string]bool)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if s.health == nil {
+		s.health = make(map[string]*MasterHealth)
+	}
+
+	for _, address := range s.services.Config.Values.Poll.KnownMasters {
+		if known[address] {
+			continue
+		}
+
+		known[address] = true
+
+		if s.isSelf(address) {
+			continue
+		}
+
+		h, ok := s.health[address]
+		if !ok {
+			h = &MasterHealth{
+				Address: address,
+			}
+			s.health[address] = h
+		}
+
+		// polls start on a ticker, so allow for a little drift when checking if a master is due
+		if now.Add(s.duration / 2).Before(h.NextAttempt) {
+			skipped++
+			continue
+		}
+
+		due = append(due, address)
+	}
+
+	for k := range s.health {
+		if !known[k] {
+			delete(s.health, k)
+		}
+	}
+
+	// a master removed from the list and added back is checked again
+	for k := range s.self {
+		if !known[k] {
+			delete(s.self, k)
+		}
+	}
+
+	return due, skipped
+}
+
+// recordResult updates the health of a master after a poll, failing masters are polled exponentially less often
+func (s *Service) recordResult(r *pollResult) {
+	s.Lock()
+	defer s.Unlock()
+
+	h, ok := s.health[r.address]
+	if !ok {
+		return
+	}
+
+	now := r.attempted
+
+	if r.resolved != "" && r.resolved != h.ResolvedAddress {
+		if h.ResolvedAddress != "" {
+			s.log.Logf("master %s now resolves to %s, was %s", r.address, r.resolved, h.ResolvedAddress)
+		}
+
+		h.ResolvedAddress = r.resolved
+	}
+
+	h.Attempts++
+	h.LastAttempt = now
+
+	if r.err == nil {
+		if h.ConsecutiveFailures > 0 {
+			s.log.Logf("master %s answered again after %d failed polls", r.address, h.ConsecutiveFailures)
+		}
+
+		h.Successes++
+		h.ConsecutiveFailures = 0
+		h.LastSuccess = now
+		h.LastError = ""
+		h.NextAttempt = time.Time{}
+		h.ServersContributed = len(r.master.Servers)
+
+		if len(h.Latency) >= maxLatencyHistory {
+			h.Latency = append(h.Latency[:0], h.Latency[1:]...)
+		}
+
+		h.Latency = append(h.Latency, r.master.Ping)
+
+		return
+	}
+
+	h.ConsecutiveFailures++
+	h.LastError = r.err.Error()
+
+	maxBackoff := s.services.Config.Values.Poll.MaxBackoff.Duration
+	if h.ConsecutiveFailures < 2 || maxBackoff <= 0 { //nolint:gomnd
+		return
+	}
+
+	// the first failure is retried on the next tick, after that the delay doubles with each failure
+	backoff := s.duration
+
+	for i := 1; i < h.ConsecutiveFailures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	h.NextAttempt = now.Add(backoff)
+	s.log.LogAlertf("master %s failed %d polls in a row, next attempt in %s", r.address, h.ConsecutiveFailures, backoff)
+}
+
+// MasterHealth returns a copy of the health records of every known master, ordered by address
+func (s *Service) MasterHealth() []MasterHealth {
+	s.Lock()
+	defer s.Unlock()
+
+	out := make([]MasterHealth, 0, len(s.health))
+
+	for _, v := range s.health {
+		h := *v
+		h.Latency = append([]time.Duration{}, v.Latency...)
+		out = append(out, h)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Address < out[j].Address
+	})
+
+	return out
+}
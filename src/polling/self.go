@@ -0,0 +1,145 @@
+package polling
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/StarsiegePlayers/neos-thicc-master/src/config"
+
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/query"
+)
+
+// selfMatch is a known master detected as this master, along with the address it resolved to at the time
+type selfMatch struct {
+	reason   string
+	resolved string
+	byAnswer bool
+}
+
+// detectSelf checks the known masters for addresses pointing back at this master. every entry is resolved on
+// every poll as addresses can change, entries that no longer point at this master are polled again
+func (s *Service) detectSelf() {
+	ports := s.listenPorts()
+	localIPs := s.localIPs()
+
+	for _, address := range s.services.Config.Values.Poll.KnownMasters {
+		addr, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			continue
+		}
+
+		if reason, ok := matchSelf(addr, ports, localIPs); ok {
+			s.markSelf(address, addr.String(), reason, false)
+			continue
+		}
+
+		s.Lock()
+		match, known := s.self[address]
+		s.Unlock()
+
+		// an entry that answered as this master is trusted for as long as it resolves to the same address
+		if !known || (match.byAnswer && match.resolved == addr.String()) {
+			continue
+		}
+
+		s.clearSelf(address, addr.String())
+	}
+}
+
+// matchSelf checks if a resolved address points at this master, returning why it does
+func matchSelf(addr *net.UDPAddr, ports map[int]bool, localIPs map[string]string) (reason string, ok bool) {
+	if !ports[addr.Port] {
+		return "", false
+	}
+
+	if addr.IP.IsLoopback() || addr.IP.IsUnspecified() {
+		return "it resolves to a loopback address on our port", true
+	}
+
+	reason, ok = localIPs[addr.IP.String()]
+
+	return reason, ok
+}
+
+// isSelfResponse checks if a polled master answered with our own id and name. masters left on the default id
+// without a hostname all look the same, so the answer is only trusted once both have been set
+func (s *Service) isSelfResponse(m *query.MasterQuery) bool {
+	cfg := &s.services.Config.Values.Service
+
+	if cfg.ID == config.DefaultMasterID || strings.TrimSpace(cfg.Hostname) == "" {
+		return false
+	}
+
+	return m.MasterID == cfg.ID && m.CommonName == strings.ReplaceAll(cfg.Hostname, `\n`, "")
+}
+
+// markSelf skips a known master from now on, the warning is only logged the first time
+func (s *Service) markSelf(address string, resolved string, reason string, byAnswer bool) {
+	s.Lock()
+	_, known := s.self[address]
+	s.self[address] = &selfMatch{
+		reason:   reason,
+		resolved: resolved,
+		byAnswer: byAnswer,
+	}
+	delete(s.health, address)
+	s.Unlock()
+
+	if !known {
+		s.log.LogAlertf("known master %s is this master, %s. it won't be polled, consider removing it from the known masters", address, reason)
+	}
+}
+
+// clearSelf polls a known master again once it no longer points at this master
+func (s *Service) clearSelf(address string, resolved string) {
+	s.Lock()
+	delete(s.self, address)
+	s.Unlock()
+
+	s.log.Logf("known master %s resolves to %s which is no longer this master, it will be polled again", address, resolved)
+}
+
+// isSelf checks if a known master was detected as this master, it expects the lock to be held
+func (s *Service) isSelf(address string) bool {
+	_, ok := s.self[address]
+
+	return ok
+}
+
+// listenPorts returns every port the master service listens on
+func (s *Service) listenPorts() map[int]bool {
+	cfg := &s.services.Config.Values.Service.Listen
+
+	ports := map[int]bool{
+		int(cfg.Port): true,
+	}
+
+	for _, v := range cfg.Additional {
+		_, portString, err := net.SplitHostPort(v)
+		if err != nil {
+			continue
+		}
+
+		if port, err := strconv.Atoi(portString); err == nil {
+			ports[port] = true
+		}
+	}
+
+	return ports
+}
+
+// localIPs returns the addresses of the local interfaces and the STUN address, along with why they match
+func (s *Service) localIPs() map[string]string {
+	out := make(map[string]string)
+
+	for _, v := range s.services.STUN.LocalAddresses() {
+		out[v.IP.String()] = "it resolves to the local address " + v.IP.String()
+	}
+
+	if ip := net.ParseIP(s.services.STUN.Get("")); ip != nil {
+		out[ip.String()] = "it resolves to our external address " + ip.String()
+	}
+
+	return out
+}
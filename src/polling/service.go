@@ -1,6 +1,9 @@
 package polling
 
 import (
+	"fmt"
+	"math/rand"
+	"net"
 	"sync"
 	"time"
 
@@ -8,8 +11,9 @@ import (
 	"github.com/StarsiegePlayers/neos-thicc-master/src/log"
 	"github.com/StarsiegePlayers/neos-thicc-master/src/master"
 	"github.com/StarsiegePlayers/neos-thicc-master/src/service"
+	"github.com/StarsiegePlayers/neos-thicc-master/src/stun"
 
-	"github.com/StarsiegePlayers/darkstar-query-go/v2"
+	"github.com/StarsiegePlayers/darkstar-query-go/v2/protocol"
 	"github.com/StarsiegePlayers/darkstar-query-go/v2/query"
 	"github.com/StarsiegePlayers/darkstar-query-go/v2/server"
 )
@@ -19,11 +23,22 @@ type Service struct {
 	*time.Ticker
 
 	PollMasterInfo *PollMasterInfo
+	health         map[string]*MasterHealth
+	results        map[string]*pollResult
+	consistency    *ConsistencyReport
+
+	// self holds the known masters that turned out to be this master, and how they were detected
+	self map[string]*selfMatch
+
+	// rand picks the jitter delays, quit is closed to cancel the polls of a stopped service
+	rand *rand.Rand
+	quit chan struct{}
 
 	services struct {
 		Map    *map[service.ID]service.Interface
 		Config *config.Service
 		Master *master.Service
+		STUN   *stun.Service
 	}
 	status   service.LifeCycle
 	duration time.Duration
@@ -36,13 +51,25 @@ type Service struct {
 type PollMasterInfo struct {
 	Masters []*query.MasterQuery
 	Games   map[string]*server.Server
+	Origins map[string]string
 	Errors  []error
 }
 
+type pollResult struct {
+	address   string
+	resolved  string
+	master    *query.MasterQuery
+	attempted time.Time
+	err       error
+}
+
 func (s *Service) Init(services *map[service.ID]service.Interface) (err error) {
 	s.services.Map = services
 	s.services.Config = (*s.services.Map)[service.Config].(*config.Service)
 	s.services.Master = (*s.services.Map)[service.Master].(*master.Service)
+	s.services.STUN = (*s.services.Map)[service.STUN].(*stun.Service)
+	s.self = make(map[string]*selfMatch)
+	s.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 	s.log = (*s.services.Map)[service.Log].(*log.Service).NewLogger(service.Poll)
 	s.status = service.Starting
 
@@ -52,7 +79,7 @@ func (s *Service) Init(services *map[service.ID]service.Interface) (err error) {
 func (s *Service) Rehash() {
 	if s.duration != s.services.Config.Values.Poll.Interval.Duration {
 		s.log.Logf("restarting poll service")
-		s.Stop()
+		s.stop()
 
 		go s.Run()
 	}
@@ -64,32 +91,234 @@ func (s *Service) Run() {
 	s.log.Logf("will run every %s", s.duration.String())
 	s.log.Logf("known masters are %s", s.services.Config.Values.Poll.KnownMasters)
 
-	s.Ticker = time.NewTicker(s.duration)
-	s.query()
+	ticker := time.NewTicker(s.duration)
+	quit := make(chan struct{})
+
+	s.Lock()
+	s.Ticker = ticker
+	s.quit = quit
+	s.Unlock()
+
+	s.query(quit)
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			s.query(quit)
+		}
+	}
+}
+
+// stop stops the ticker and cancels the polls still waiting out their jitter
+func (s *Service) stop() {
+	s.Lock()
+	defer s.Unlock()
 
-	for range s.C {
-		s.query()
+	if s.quit == nil {
+		return
 	}
+
+	s.Ticker.Stop()
+	close(s.quit)
+	s.quit = nil
 }
 
-func (s *Service) query() {
-	q := darkstar.NewQuery(s.services.Config.Values.Advanced.Network.ConnectionTimeout.Duration, s.services.Config.Values.Advanced.Verbose)
-	q.Addresses = s.services.Config.Values.Poll.KnownMasters
+func (s *Service) query(quit <-chan struct{}) {
+	s.detectSelf()
+	due, skipped := s.dueMasters(time.Now())
+
+	options := &protocol.Options{
+		Timeout:              s.services.Config.Values.Poll.Timeout.Duration,
+		Debug:                s.services.Config.Values.Advanced.Verbose,
+		MaxServerPacketSize:  protocol.MaxDataSize,
+		MaxNetworkPacketSize: protocol.MaxPacketSize,
+	}
+	jitter := s.jitter()
+	await := make(chan *pollResult, len(due))
+
+	// every master is polled on its own, so a slow or dead master doesn't hold up the others
+	for _, address := range due {
+		go s.pollMaster(address, s.jitterDelay(jitter), options, quit, await)
+	}
+
+	for range due {
+		r := <-await
+
+		// the poll was cancelled by a shutdown or a restart
+		if r == nil {
+			continue
+		}
 
-	pm := new(PollMasterInfo)
-	pm.Masters, pm.Games, pm.Errors = q.Masters()
-	s.log.Logf("found %d games on %d masters", len(pm.Games), len(pm.Masters))
+		// an alias of our own address can only be told apart by the answer
+		if r.err == nil && s.isSelfResponse(r.master) {
+			s.markSelf(r.address, r.resolved, "it answered with our master id and name", true)
+			continue
+		}
+
+		s.recordResult(r)
+		s.mergeResult(r)
+
+		if r.err != nil {
+			continue
+		}
+
+		// servers are registered as each master answers, the registry skips the ones it already knows
+		origins := make(map[string]string, len(r.master.Servers))
+		for k := range r.master.Servers {
+			origins[k] = r.address
+		}
+
+		s.services.Master.RegisterExternalServerList(r.master.Servers, origins)
+	}
+
+	select {
+	case <-quit:
+		return
+	default:
+	}
 
 	s.Lock()
-	s.PollMasterInfo = pm
+	pm := s.PollMasterInfo
 	s.Unlock()
 
-	s.services.Master.RegisterExternalServerList(pm.Games)
+	if pm == nil {
+		return
+	}
+
+	if skipped > 0 {
+		s.log.Logf("found %d games on %d masters, skipped %d failing masters", len(pm.Games), len(pm.Masters), skipped)
+	} else {
+		s.log.Logf("found %d games on %d masters", len(pm.Games), len(pm.Masters))
+	}
+
+	report := s.buildConsistencyReport(pm.Masters, s.services.Master.PublishedServers())
+	s.logConsistencyReport(report)
+
+	s.Lock()
+	s.consistency = report
+	s.Unlock()
+}
+
+// jitter returns the longest random delay before a master is polled, it is kept under half the poll interval
+func (s *Service) jitter() time.Duration {
+	jitter := s.services.Config.Values.Poll.Jitter.Duration
+	if jitter > s.duration/2 {
+		jitter = s.duration / 2
+	}
+
+	return jitter
+}
+
+// jitterDelay picks a random delay of up to jitter, the source is shared with a poll left over from a restart
+func (s *Service) jitterDelay(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return time.Duration(s.rand.Int63n(int64(jitter))) //nolint:gosec
+}
+
+// pollMaster queries a single master after a delay, the address is resolved again on every poll so masters on
+// dynamic dns are followed when their address changes. a poll cancelled during its delay sends nil
+func (s *Service) pollMaster(address string, delay time.Duration, options *protocol.Options, quit <-chan struct{}, await chan<- *pollResult) {
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-quit:
+			timer.Stop()
+			await <- nil
+
+			return
+		}
+	}
+
+	r := &pollResult{
+		address:   address,
+		attempted: time.Now(),
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		r.err = fmt.Errorf("master: [%s]: unable to resolve address [%w]", address, err)
+		await <- r
+
+		return
+	}
+
+	r.resolved = addr.String()
+	r.master = query.NewMasterQueryWithOptions(r.resolved, options)
+	r.err = r.master.Query()
+
+	// results are tracked under the configured address rather than the one it resolved to
+	r.master.Address = address
+
+	await <- r
+}
+
+// mergeResult replaces the previous result of a master and rebuilds PollMasterInfo from the latest result of
+// every known master, so results are visible as soon as they arrive
+func (s *Service) mergeResult(r *pollResult) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.results == nil {
+		s.results = make(map[string]*pollResult)
+	}
+
+	s.results[r.address] = r
+
+	pm := &PollMasterInfo{
+		Masters: make([]*query.MasterQuery, 0),
+		Games:   make(map[string]*server.Server),
+		Origins: make(map[string]string),
+		Errors:  make([]error, 0),
+	}
+
+	known := make(map[string]bool)
+
+	for _, address := range s.services.Config.Values.Poll.KnownMasters {
+		result, ok := s.results[address]
+		if known[address] || !ok {
+			continue
+		}
+
+		known[address] = true
+
+		if result.err != nil {
+			pm.Errors = append(pm.Errors, result.err)
+			continue
+		}
+
+		pm.Masters = append(pm.Masters, result.master)
+
+		for k, v := range result.master.Servers {
+			if _, ok := pm.Games[k]; !ok {
+				pm.Games[k] = v
+				pm.Origins[k] = address
+			}
+		}
+	}
+
+	// results of masters that were removed from the list or turned out to be this master are dropped
+	for k := range s.results {
+		if !known[k] || s.isSelf(k) {
+			delete(s.results, k)
+		}
+	}
+
+	s.PollMasterInfo = pm
 }
 
 func (s *Service) Shutdown() {
 	s.status = service.Stopping
-	s.Stop()
+	s.stop()
 	s.status = service.Stopped
 	s.log.Logf("shutdown complete")
 }
@@ -104,6 +104,10 @@ func (s *Server) Run() {
 	s.Services[service.Config].(*config.Service).Callback.StartStopServices = s.startStopServices
 	s.Services[service.Config].(*config.Service).Callback.Shutdown = s.Shutdown
 	s.Services[service.Config].(*config.Service).Callback.Restart = s.Restart
+	s.Services[service.STUN].(*stun.Service).SetAddressesChanged(s.relocalize)
+
+	// catch up with a STUN lookup that finished while the other services were starting
+	s.relocalize()
 	s.status = service.Running
 
 	s.Logs.startup.Logf("startup complete")
@@ -133,6 +137,15 @@ func (s *Server) Rehash() {
 	s.status = p
 }
 
+// relocalize updates every service that advertises addresses based on the external ip address or local networks
+func (s *Server) relocalize() {
+	for _, v := range s.Services {
+		if sv, ok := v.(service.Localizable); ok {
+			sv.Relocalize()
+		}
+	}
+}
+
 func (s *Server) Shutdown() {
 	s.status = service.Stopping
 
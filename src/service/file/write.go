@@ -0,0 +1,18 @@
+package file
+
+import (
+	"os"
+)
+
+// WriteAtomic writes data to a temporary file next to fileName and renames it into place,
+// so a crash mid-write leaves the previous file intact instead of a truncated one
+func WriteAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmpFileName := fileName + ".tmp"
+
+	err := os.WriteFile(tmpFileName, data, perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFileName, fileName)
+}
@@ -35,6 +35,7 @@ const (
 	HeartbeatLog
 	ServerRegistrationLog
 	BannedTrafficLog
+	NamePolicyLog
 )
 
 var (
@@ -59,6 +60,7 @@ var (
 		HeartbeatLog:          {HeartbeatLog, "heartbeat", "Server Heartbeats"},
 		ServerRegistrationLog: {ServerRegistrationLog, "registration", "Server Registrations"},
 		BannedTrafficLog:      {BannedTrafficLog, "banned", "Banned Client/Server traffic"},
+		NamePolicyLog:         {NamePolicyLog, "name-policy", "Server Name Policy Hits"},
 	}
 
 	ListByTag = map[string]Info{}
@@ -28,3 +28,8 @@ type Runnable interface {
 	Shutdown()
 	Rehashable
 }
+
+// Localizable services advertise addresses that depend on the external ip address and local networks
+type Localizable interface {
+	Relocalize()
+}
@@ -0,0 +1,91 @@
+package stun
+
+import (
+	"net"
+	"time"
+)
+
+// LocalAddresses returns the networks of the local interfaces, the slice is replaced rather than modified
+// when the interfaces change, so it can be kept and read without locking
+func (s *Service) LocalAddresses() []*net.IPNet {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.localAddresses
+}
+
+// resetScanTicker replaces the interface scan ticker after the scan interval changed, no ticker is kept while
+// scans are disabled by setting the interval to 0. it expects the lock to be held
+func (s *Service) resetScanTicker() {
+	if s.scanTicker != nil {
+		s.scanTicker.Stop()
+		s.scanTicker = nil
+	}
+
+	if s.scanInterval > 0 {
+		s.scanTicker = time.NewTicker(s.scanInterval)
+	}
+}
+
+// scanChannel returns the channel of the interface scan ticker, a nil channel is never selected so scans
+// don't run while they are disabled. it expects the lock to be held
+func (s *Service) scanChannel() <-chan time.Time {
+	if s.scanTicker == nil {
+		return nil
+	}
+
+	return s.scanTicker.C
+}
+
+// scanInterfaces recomputes the local networks, logging every network that was added or removed and calling
+// addressesChanged if anything changed
+func (s *Service) scanInterfaces() {
+	current, err := s.generateUniqueLocalAddresses()
+	if err != nil {
+		// an empty list would drop every local network without calling addressesChanged
+		s.log.LogAlertf("unable to list the local interfaces, keeping the previous networks [%s]", err)
+		return
+	}
+
+	s.Lock()
+	previous := s.localAddresses
+	first := previous == nil
+	s.localAddresses = current
+	callback := s.addressesChanged
+	s.Unlock()
+
+	if first {
+		s.log.Logf("found %d local networks", len(current))
+		return
+	}
+
+	added, removed := diffNetworks(previous, current), diffNetworks(current, previous)
+
+	for _, v := range added {
+		s.log.Logf("local network %s added", v)
+	}
+
+	for _, v := range removed {
+		s.log.LogAlertf("local network %s removed", v)
+	}
+
+	if (len(added) > 0 || len(removed) > 0) && callback != nil {
+		callback()
+	}
+}
+
+// diffNetworks returns the networks in b that aren't in a
+func diffNetworks(a []*net.IPNet, b []*net.IPNet) (out []*net.IPNet) {
+	seen := make(map[string]bool, len(a))
+	for _, v := range a {
+		seen[v.String()] = true
+	}
+
+	for _, v := range b {
+		if !seen[v.String()] {
+			out = append(out, v)
+		}
+	}
+
+	return
+}
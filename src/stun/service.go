@@ -3,6 +3,9 @@ package stun
 import (
 	"bytes"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/StarsiegePlayers/neos-thicc-master/src/config"
 	"github.com/StarsiegePlayers/neos-thicc-master/src/log"
@@ -11,11 +14,41 @@ import (
 	"github.com/pion/stun"
 )
 
+const (
+	// maxAttempts is the number of recent lookups kept for the admin api
+	maxAttempts = 16
+
+	sourceStatic   = "static"
+	sourceDisabled = "disabled"
+)
+
 type Service struct {
-	stunServers  []string
-	cachedOutput string
+	sync.Mutex
+
+	stunServers []string
+	externalIP  string
+	static      bool
+	enabled     bool
+	interval    time.Duration
+	lastRefresh time.Time
+	attempts    []Attempt
+
+	// a refresh asked for while another is running is queued rather than dropped, as a rehash may have
+	// changed the settings the running refresh has already read
+	refreshing     bool
+	refreshPending bool
+
+	ticker *time.Ticker
+	stop   chan struct{}
+
+	// scanTicker is nil while interface scans are disabled, scanReset tells Run to pick up a replaced ticker
+	localAddresses []*net.IPNet
+	scanInterval   time.Duration
+	scanTicker     *time.Ticker
+	scanReset      chan struct{}
 
-	LocalAddresses []*net.IPNet
+	// addressesChanged is called whenever the external ip address or the local networks change
+	addressesChanged func()
 
 	services struct {
 		Map    *map[service.ID]service.Interface
@@ -26,14 +59,32 @@ type Service struct {
 	status service.LifeCycle
 
 	service.Interface
-	service.Rehashable
+	service.Runnable
+}
+
+// Attempt is the result of a single lookup against a STUN server
+type Attempt struct {
+	Server  string
+	Time    time.Time
+	Address string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+}
+
+type Stats struct {
+	Enabled         bool
+	Static          bool
+	ExternalIP      string
+	RefreshInterval string
+	LastRefresh     time.Time
+	Attempts        []Attempt
 }
 
 func (s *Service) Init(services *map[service.ID]service.Interface) error {
 	s.log = (*services)[service.Log].(*log.Service).NewLogger(service.STUN)
 	s.services.Map = services
 	s.services.Config = (*services)[service.Config].(*config.Service)
-	s.status = service.Static
+	s.status = service.Starting
+	s.attempts = make([]Attempt, 0, maxAttempts)
 	s.Rehash()
 
 	return nil
@@ -43,58 +94,287 @@ func (s *Service) Status() service.LifeCycle {
 	return s.status
 }
 
+// Run looks up the external ip address and keeps it up to date, the first lookup happens here rather than
+// during Init so an unreachable STUN server doesn't hold up the startup
+func (s *Service) Run() {
+	s.Lock()
+	s.stop = make(chan struct{})
+	s.scanReset = make(chan struct{}, 1)
+	s.ticker = time.NewTicker(s.interval)
+	s.resetScanTicker()
+	stop, ticker, scanReset, scan := s.stop, s.ticker, s.scanReset, s.scanChannel()
+	s.Unlock()
+
+	s.status = service.Running
+	s.refresh()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.refresh()
+		case <-scan:
+			s.scanInterfaces()
+		case <-scanReset:
+			s.Lock()
+			scan = s.scanChannel()
+			s.Unlock()
+		}
+	}
+}
+
+func (s *Service) Shutdown() {
+	s.status = service.Stopping
+
+	s.Lock()
+	if s.stop != nil {
+		s.ticker.Stop()
+		close(s.stop)
+		s.stop = nil
+	}
+
+	if s.scanTicker != nil {
+		s.scanTicker.Stop()
+		s.scanTicker = nil
+	}
+	s.Unlock()
+
+	s.status = service.Stopped
+	s.log.Logf("shutdown complete")
+}
+
 func (s *Service) Rehash() {
 	p := s.status
 	s.status = service.Rehashing
-	s.stunServers = s.services.Config.Values.Advanced.Network.StunServers
-	s.LocalAddresses = s.generateUniqueLocalAddresses()
-	s.cachedOutput = ""
-	s.cachedOutput = s.Get("")
+
+	cfg := &s.services.Config.Values.Advanced.Network
+
+	s.Lock()
+	s.stunServers = cfg.StunServers
+	s.enabled = cfg.StunEnabled
+	s.interval = cfg.StunRefresh.Duration
+	s.scanInterval = cfg.InterfaceScan.Duration
+
+	if s.interval <= 0 {
+		s.interval = 30 * time.Minute //nolint:gomnd
+	}
+
+	running := s.stop != nil
+	if running {
+		s.ticker.Reset(s.interval)
+		s.resetScanTicker()
+
+		select {
+		case s.scanReset <- struct{}{}:
+		default:
+		}
+	}
+	s.Unlock()
+
+	s.scanInterfaces()
+
 	s.status = p
+
+	// the first lookup is left to Run, later ones are done in the background so a rehash doesn't wait on the network
+	if running {
+		go s.refresh()
+	}
 }
 
+// Get returns the last known external ip address, which is empty until the first lookup succeeds or if STUN is
+// disabled without a static address
 func (s *Service) Get(string) string {
-	if s.cachedOutput != "" {
-		return s.cachedOutput
+	s.Lock()
+	defer s.Unlock()
+
+	return s.externalIP
+}
+
+// SetAddressesChanged sets the function called whenever the external ip address or the local networks change
+func (s *Service) SetAddressesChanged(callback func()) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.addressesChanged = callback
+}
+
+// refresh updates the external ip address, a refresh asked for while one is running is run once it finishes
+func (s *Service) refresh() {
+	s.Lock()
+	if s.refreshing {
+		s.refreshPending = true
+		s.Unlock()
+
+		return
 	}
 
-	for _, stunServer := range s.stunServers {
-		c, err := stun.Dial("udp4", stunServer)
-		if err != nil {
-			s.log.LogAlertf("dial error [%s]", err)
-			continue
+	s.refreshing = true
+	s.Unlock()
+
+	for {
+		s.refreshAddress()
+
+		s.Lock()
+		if !s.refreshPending {
+			s.refreshing = false
+			s.Unlock()
+
+			return
 		}
 
-		if err = c.Do(stun.MustBuild(stun.TransactionID, stun.BindingRequest), func(res stun.Event) {
-			if res.Error != nil {
-				s.log.LogAlertf("packet building error [%s]", res.Error)
-				return
-			}
-			var xorAddr stun.XORMappedAddress
-			if getErr := xorAddr.GetFrom(res.Message); getErr != nil {
-				s.log.LogAlertf("xorAddress error [%s]", getErr)
-				return
-			}
-			s.cachedOutput = xorAddr.IP.String()
-		}); err != nil {
-			s.log.LogAlertf("error during STUN-do [%s]", err)
-			continue
+		s.refreshPending = false
+		s.Unlock()
+	}
+}
+
+// refreshAddress updates the external ip address from the static address or the STUN servers, calling
+// addressesChanged if it changed
+func (s *Service) refreshAddress() {
+	s.Lock()
+	servers := s.stunServers
+	enabled := s.enabled
+	s.Unlock()
+
+	static := strings.TrimSpace(s.services.Config.Values.Advanced.Network.ExternalIP)
+
+	var (
+		address string
+		source  string
+	)
+
+	switch {
+	case static != "":
+		if ip := net.ParseIP(static); ip != nil {
+			address, source = ip.String(), sourceStatic
+		} else {
+			s.log.LogAlertf("unable to parse external ip address %s", static)
+		}
+
+	case !enabled:
+		source = sourceDisabled
+
+	default:
+		address, source = s.lookup(servers)
+	}
+
+	s.Lock()
+	previous := s.externalIP
+	s.lastRefresh = time.Now()
+	s.static = source == sourceStatic
+
+	// a failed lookup keeps the last known address, as it most likely hasn't changed
+	if address != "" || source == sourceDisabled {
+		s.externalIP = address
+	}
+
+	current := s.externalIP
+	callback := s.addressesChanged
+	s.Unlock()
+
+	if current == previous {
+		return
+	}
+
+	switch {
+	case current == "":
+		s.log.Logf("STUN is disabled and no external ip address is set, servers won't be localized")
+	case previous == "":
+		s.log.Logf("external ip address is %s (%s)", current, source)
+	default:
+		s.log.LogAlertf("external ip address changed from %s to %s (%s)", previous, current, source)
+	}
+
+	if callback != nil {
+		callback()
+	}
+}
+
+// lookup asks each STUN server in turn for our external address, returning the first answer
+func (s *Service) lookup(servers []string) (address string, source string) {
+	for _, stunServer := range servers {
+		attempt := Attempt{
+			Server: stunServer,
+			Time:   time.Now(),
+		}
+
+		address, attempt.Error = s.query(stunServer)
+		attempt.Address = address
+
+		s.Lock()
+		if len(s.attempts) >= maxAttempts {
+			s.attempts = append(s.attempts[:0], s.attempts[1:]...)
 		}
 
+		s.attempts = append(s.attempts, attempt)
+		s.Unlock()
+
+		if address != "" {
+			return address, stunServer
+		}
+	}
+
+	s.log.LogAlertf("unable to look up the external ip address from %d STUN servers", len(servers))
+
+	return "", ""
+}
+
+// query sends a single binding request, returning the mapped address or a description of what went wrong
+func (s *Service) query(stunServer string) (address string, errString string) {
+	c, err := stun.Dial("udp4", stunServer)
+	if err != nil {
+		s.log.LogAlertf("dial error [%s]", err)
+		return "", err.Error()
+	}
+
+	defer func() {
 		if err := c.Close(); err != nil {
 			s.log.LogAlertf("error closing STUN client [%s]", err)
 		}
+	}()
 
-		if s.cachedOutput != "" {
-			break
+	if err = c.Do(stun.MustBuild(stun.TransactionID, stun.BindingRequest), func(res stun.Event) {
+		if res.Error != nil {
+			s.log.LogAlertf("packet building error [%s]", res.Error)
+			errString = res.Error.Error()
+
+			return
 		}
+
+		var xorAddr stun.XORMappedAddress
+		if getErr := xorAddr.GetFrom(res.Message); getErr != nil {
+			s.log.LogAlertf("xorAddress error [%s]", getErr)
+			errString = getErr.Error()
+
+			return
+		}
+
+		address = xorAddr.IP.String()
+	}); err != nil {
+		s.log.LogAlertf("error during STUN-do [%s]", err)
+		return "", err.Error()
 	}
 
-	return s.cachedOutput
+	return address, errString
+}
+
+// Stats returns the current external address and the most recent lookups, newest last
+func (s *Service) Stats() Stats {
+	s.Lock()
+	defer s.Unlock()
+
+	return Stats{
+		Enabled:         s.enabled,
+		Static:          s.static,
+		ExternalIP:      s.externalIP,
+		RefreshInterval: s.interval.String(),
+		LastRefresh:     s.lastRefresh,
+		Attempts:        append([]Attempt{}, s.attempts...),
+	}
 }
 
 func (s *Service) IsInLocalNets(host string) (bool, net.IP) {
-	for _, v := range s.LocalAddresses {
+	for _, v := range s.LocalAddresses() {
 		if v.Contains(net.ParseIP(host)) {
 			return true, v.IP
 		}
@@ -102,12 +382,12 @@ func (s *Service) IsInLocalNets(host string) (bool, net.IP) {
 	return false, nil
 }
 
-func (s *Service) generateUniqueLocalAddresses() (output []*net.IPNet) {
+func (s *Service) generateUniqueLocalAddresses() (output []*net.IPNet, err error) {
 	addressList := make([]*net.IPNet, 0)
 
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		return
+		return nil, err
 	}
 
 	for _, i := range ifaces {
@@ -117,9 +397,17 @@ func (s *Service) generateUniqueLocalAddresses() (output []*net.IPNet) {
 		}
 
 		for k := range addrs {
-			if addrs[k].(*net.IPNet).IP.To4() != nil {
-				addressList = append(addressList, addrs[k].(*net.IPNet))
+			ipNet, ok := addrs[k].(*net.IPNet)
+			if !ok {
+				continue
+			}
+
+			// link-local IPv6 addresses need a zone to be usable, so they can't be handed out to clients
+			if ipNet.IP.To4() == nil && ipNet.IP.IsLinkLocalUnicast() {
+				continue
 			}
+
+			addressList = append(addressList, ipNet)
 		}
 	}
 
@@ -91,7 +91,7 @@ func (s *Service) Get(host string) string {
 			UserNum:       numbers.Ordinalize(s.services.Stats.GetDailyHostNumber(host)),
 			UserTotal:     s.services.Stats.GetDailyClientsTotal(),
 			ActiveServers: s.services.Stats.GetTotalServersWithPlayers(),
-			TotalServers:  len(s.services.Master.ServerList),
+			TotalServers:  len(s.services.Master.Snapshot().Servers),
 			IP:            host,
 			NL:            "\\n",
 		})